@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// These are the exit codes used by every command.
+const (
+	ExitSuccess = 0
+	ExitFailure = 1
+	ExitUsage   = 2
+)
+
+const programName = "hn-ranker"
+
+// These are the values for the -format flag of
+// commands which print results.
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+)
+
+// A Command is a subcommand of the program.
+type Command struct {
+	Name    string
+	Summary string
+
+	// Args describes the positional arguments, as in
+	// "<list.json> <post-dir>".
+	// MinArgs and MaxArgs bound how many there are.
+	Args    string
+	MinArgs int
+	MaxArgs int
+
+	// Setup adds the command's flags to a FlagSet and
+	// returns a function which runs the command with
+	// the remaining arguments once the flags have been
+	// parsed.
+	Setup func(flags *flag.FlagSet) func(args []string) error
+}
+
+var commands = map[string]*Command{}
+
+// RegisterCommand adds a command to the program.
+func RegisterCommand(c *Command) {
+	if commands[c.Name] != nil {
+		panic("duplicate command: " + c.Name)
+	}
+	commands[c.Name] = c
+}
+
+func sortedCommands() []*Command {
+	var res []*Command
+	for _, c := range commands {
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// A usageError indicates that a command was invoked
+// incorrectly.
+type usageError struct {
+	message string
+}
+
+func (u *usageError) Error() string {
+	return u.message
+}
+
+func newUsageError(format string, args ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+// Execute parses the flags and arguments for c and
+// runs it.
+// It returns flag.ErrHelp if help was requested, or a
+// *usageError if the arguments are invalid.
+func (c *Command) Execute(args []string) error {
+	flags, run := c.flagSet()
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{message: err.Error()}
+	}
+	if n := flags.NArg(); n < c.MinArgs || (c.MaxArgs >= 0 && n > c.MaxArgs) {
+		return newUsageError("wrong number of arguments")
+	}
+	return run(flags.Args())
+}
+
+func (c *Command) flagSet() (*flag.FlagSet, func(args []string) error) {
+	flags := flag.NewFlagSet(c.Name, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	var run func(args []string) error
+	if c.Setup != nil {
+		run = c.Setup(flags)
+	}
+	return flags, run
+}
+
+func (c *Command) usageLine() string {
+	res := programName + " " + c.Name
+	if c.hasFlags() {
+		res += " [flags]"
+	}
+	if c.Args != "" {
+		res += " " + c.Args
+	}
+	return res
+}
+
+func (c *Command) hasFlags() bool {
+	flags, _ := c.flagSet()
+	var res bool
+	flags.VisitAll(func(*flag.Flag) {
+		res = true
+	})
+	return res
+}
+
+// PrintHelp writes the usage and flags of c.
+func (c *Command) PrintHelp(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s\n\n%s\n", c.usageLine(), c.Summary)
+	if c.hasFlags() {
+		flags, _ := c.flagSet()
+		fmt.Fprintln(w, "\nFlags:")
+		flags.SetOutput(w)
+		flags.PrintDefaults()
+	}
+}
+
+// PrintUsage writes the list of commands.
+func PrintUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags] [arguments]\n\nCommands:\n", programName)
+	for _, c := range sortedCommands() {
+		fmt.Fprintf(w, "  %-12s %s\n", c.Name, c.Summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s help <command>' for more about a command.\n", programName)
+}
+
+// Run runs the command named by the first argument and
+// returns an exit code.
+func Run(args []string) int {
+	if len(args) == 0 {
+		PrintUsage(os.Stderr)
+		return ExitUsage
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		PrintUsage(os.Stdout)
+		return ExitSuccess
+	}
+	c := commands[args[0]]
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "%s: unknown command: %s\n\n", programName, args[0])
+		PrintUsage(os.Stderr)
+		return ExitUsage
+	}
+
+	err := c.Execute(args[1:])
+	if err == nil {
+		return ExitSuccess
+	} else if err == flag.ErrHelp {
+		c.PrintHelp(os.Stdout)
+		return ExitSuccess
+	} else if _, ok := err.(*usageError); ok {
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n\n", programName, c.Name, err)
+		c.PrintHelp(os.Stderr)
+		return ExitUsage
+	}
+	fmt.Fprintln(os.Stderr, err)
+	return ExitFailure
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "help",
+		Summary: "Show the help for a command.",
+		Args:    "[command]",
+		MaxArgs: 1,
+		Setup: func(flags *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				if len(args) == 0 {
+					PrintUsage(os.Stdout)
+					return nil
+				}
+				c := commands[args[0]]
+				if c == nil {
+					return newUsageError("unknown command: %s", args[0])
+				}
+				c.PrintHelp(os.Stdout)
+				return nil
+			}
+		},
+	})
+	RegisterCommand(&Command{
+		Name:    "completion",
+		Summary: "Print a shell completion script for bash, zsh or fish.",
+		Args:    "<shell>",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Setup: func(flags *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				switch args[0] {
+				case "bash":
+					writeBashCompletion(os.Stdout, false)
+				case "zsh":
+					writeBashCompletion(os.Stdout, true)
+				case "fish":
+					writeFishCompletion(os.Stdout)
+				default:
+					return newUsageError("unsupported shell: %s", args[0])
+				}
+				return nil
+			}
+		},
+	})
+}
+
+func commandFlags(c *Command) []*flag.Flag {
+	flags, _ := c.flagSet()
+	var res []*flag.Flag
+	flags.VisitAll(func(f *flag.Flag) {
+		res = append(res, f)
+	})
+	return res
+}
+
+// writeBashCompletion writes a script which completes
+// command names, flags and file names.
+// Since zsh can run bash completion functions, the
+// same script is used for zsh with a short preamble.
+func writeBashCompletion(w io.Writer, zsh bool) {
+	if zsh {
+		fmt.Fprintln(w, "autoload -U +X bashcompinit && bashcompinit")
+	}
+	var names []string
+	for _, c := range sortedCommands() {
+		names = append(names, c.Name)
+	}
+	fmt.Fprintln(w, "_hn_ranker() {")
+	fmt.Fprintln(w, `  local cur="${COMP_WORDS[COMP_CWORD]}" words=""`)
+	fmt.Fprintln(w, "  if [ \"$COMP_CWORD\" -eq 1 ]; then")
+	fmt.Fprintf(w, "    COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintln(w, "    return")
+	fmt.Fprintln(w, "  fi")
+	fmt.Fprintln(w, `  case "${COMP_WORDS[1]}" in`)
+	for _, c := range sortedCommands() {
+		var words []string
+		for _, f := range commandFlags(c) {
+			words = append(words, "--"+f.Name)
+		}
+		if c.Name == "help" {
+			words = names
+		} else if c.Name == "completion" {
+			words = []string{"bash", "zsh", "fish"}
+		}
+		fmt.Fprintf(w, "    %s) words=%q ;;\n", c.Name, strings.Join(words, " "))
+	}
+	fmt.Fprintln(w, "  esac")
+	fmt.Fprintln(w, `  if [[ "$cur" == -* || "${COMP_WORDS[1]}" == help || "${COMP_WORDS[1]}" == completion ]]; then`)
+	fmt.Fprintln(w, `    COMPREPLY=($(compgen -W "$words" -- "$cur"))`)
+	fmt.Fprintln(w, "  else")
+	fmt.Fprintln(w, `    COMPREPLY=($(compgen -f -- "$cur"))`)
+	fmt.Fprintln(w, "  fi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintf(w, "complete -o filenames -F _hn_ranker %s\n", programName)
+}
+
+func writeFishCompletion(w io.Writer) {
+	for _, c := range sortedCommands() {
+		fmt.Fprintf(w, "complete -c %s -f -n __fish_use_subcommand -a %s -d %s\n",
+			programName, c.Name, fishQuote(c.Summary))
+		for _, f := range commandFlags(c) {
+			fmt.Fprintf(w, "complete -c %s -n '__fish_seen_subcommand_from %s' -l %s -d %s\n",
+				programName, c.Name, f.Name, fishQuote(f.Usage))
+		}
+	}
+	for _, c := range sortedCommands() {
+		fmt.Fprintf(w, "complete -c %s -f -n '__fish_seen_subcommand_from help' -a %s\n",
+			programName, c.Name)
+	}
+	fmt.Fprintf(w, "complete -c %s -f -n '__fish_seen_subcommand_from completion' "+
+		"-a 'bash zsh fish'\n", programName)
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `\'`, -1) + "'"
+}
+
+// addFormatFlag adds a flag for the output format of a
+// command which prints results.
+func addFormatFlag(flags *flag.FlagSet) *string {
+	return flags.String("format", TextFormat, "output format (text or json)")
+}
+
+func checkFormat(format string) error {
+	if format != TextFormat && format != JSONFormat {
+		return newUsageError("unknown output format: %s", format)
+	}
+	return nil
+}
+
+// writeJSON prints a value as indented JSON.
+func writeJSON(value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/unixpickle/hn-ranker/hnclass"
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds every setting which affects how a
+// model is trained.
+// It can be loaded from a JSON, YAML or TOML file,
+// using the names in the json tags as keys, with
+// nested tables for features and neuralnet.
+type Config struct {
+	Classifier   string  `json:"classifier"`
+	CrossFrac    float64 `json:"cross_validation_frac"`
+	ScoreBuckets string  `json:"score_buckets"`
+
+	// ValidationFrac is the fraction of the training
+	// stories held out to choose when to stop training,
+	// so that the cross validation stories are only
+	// used to score the final model.
+	ValidationFrac float64 `json:"validation_frac"`
+
+	// UsersFile is the output of `hn-ranker users`.
+	// FrontPageScore, if non-zero, is the score above
+	// which a story is assumed to have reached the
+	// front page.
+	UsersFile      string `json:"users_file"`
+	FrontPageScore int    `json:"front_page_score"`
+
+	// Split is the way stories are held out for cross
+	// validation; see splitStories.
+	// SplitGap is a duration such as "24h".
+	Split     string `json:"split"`
+	SplitSeed int64  `json:"split_seed"`
+	SplitGap  string `json:"split_gap"`
+
+	// FeatureSelection is empty to keep every
+	// feature, or a method like hnclass.ChiSquared.
+	FeatureSelection string `json:"feature_selection"`
+	ContentTopK      int    `json:"content_top_k"`
+	TitleTopK        int    `json:"title_top_k"`
+	HostTopK         int    `json:"host_top_k"`
+
+	hnclass.Config
+}
+
+// DefaultConfig returns the default settings.
+func DefaultConfig() *Config {
+	return &Config{
+		Classifier:   "neuralnet",
+		CrossFrac:    DefaultCrossFrac,
+		ScoreBuckets: DefaultScoreBuckets,
+
+		ValidationFrac: DefaultValidationFrac,
+
+		Split:  ChronologicalSplit,
+		Config: *hnclass.DefaultConfig(),
+	}
+}
+
+// Validate checks every setting in c.
+func (c *Config) Validate() error {
+	if _, ok := hnclass.ClassifierMakers[c.Classifier]; !ok {
+		return fmt.Errorf("unknown classifier: %s", c.Classifier)
+	}
+	if c.CrossFrac < 0 || c.CrossFrac >= 1 {
+		return errors.New("cross_validation_frac must be in [0, 1)")
+	}
+	if c.ValidationFrac < 0 || c.ValidationFrac >= 1 {
+		return errors.New("validation_frac must be in [0, 1)")
+	}
+	if c.FrontPageScore < 0 {
+		return errors.New("front_page_score must not be negative")
+	}
+	switch c.Split {
+	case RandomSplit, StratifiedSplit, ChronologicalSplit:
+	default:
+		return fmt.Errorf("unknown split: %s", c.Split)
+	}
+	if _, err := c.splitGap(); err != nil {
+		return err
+	}
+	if c.Features.AuthorProfileCounts && c.Split == ChronologicalSplit {
+		return fmt.Errorf("features.author_profile_counts cannot be used with the %s "+
+			"split, since author profiles include the points of the later stories used "+
+			"for validation", ChronologicalSplit)
+	}
+	switch c.FeatureSelection {
+	case "", hnclass.MutualInformation, hnclass.ChiSquared:
+	default:
+		return fmt.Errorf("unknown feature_selection: %s", c.FeatureSelection)
+	}
+	if c.ContentTopK < 0 || c.TitleTopK < 0 || c.HostTopK < 0 {
+		return errors.New("top_k settings must not be negative")
+	}
+	return c.Config.Validate()
+}
+
+func (c *Config) splitGap() (time.Duration, error) {
+	if c.SplitGap == "" {
+		return 0, nil
+	}
+	gap, err := time.ParseDuration(c.SplitGap)
+	if err != nil || gap < 0 {
+		return 0, fmt.Errorf("invalid split_gap: %s", c.SplitGap)
+	}
+	if c.Split != ChronologicalSplit {
+		return 0, fmt.Errorf("split_gap only applies to the %s split", ChronologicalSplit)
+	}
+	return gap, nil
+}
+
+func (c *Config) selectionLimits() hnclass.SelectionLimits {
+	return hnclass.SelectionLimits{
+		Content: c.ContentTopK,
+		Title:   c.TitleTopK,
+		Host:    c.HostTopK,
+	}
+}
+
+// envSettings maps this package's environment
+// variables to the settings they correspond to.
+var envSettings = map[string]string{
+	ClassifierNameEnvVar:   "classifier",
+	CrossFracEnvVar:        "cross_validation_frac",
+	ScoreBucketsEnvVar:     "score_buckets",
+	UsersFileEnvVar:        "users_file",
+	FrontPageScoreEnvVar:   "front_page_score",
+	SplitEnvVar:            "split",
+	SplitSeedEnvVar:        "split_seed",
+	SplitGapEnvVar:         "split_gap",
+	FeatureSelectionEnvVar: "feature_selection",
+	ContentTopKEnvVar:      "content_top_k",
+	TitleTopKEnvVar:        "title_top_k",
+	HostTopKEnvVar:         "host_top_k",
+}
+
+// envSetting finds the setting for an environment
+// variable.
+func envSetting(envVar string) (string, bool) {
+	if key, ok := envSettings[envVar]; ok {
+		return key, true
+	}
+	key, ok := hnclass.EnvSettings[envVar]
+	return key, ok
+}
+
+// configFlags are the flags shared by every command
+// which trains models.
+type configFlags struct {
+	file string
+	sets settingFlags
+}
+
+func addConfigFlags(flags *flag.FlagSet) *configFlags {
+	res := &configFlags{}
+	flags.StringVar(&res.file, "config", "", "config file (JSON, YAML or TOML)")
+	flags.Var(&res.sets, "set", "override a setting, as in features.stem=true "+
+		"(may be repeated)")
+	return res
+}
+
+// load builds the effective config from the defaults,
+// the config file, the environment and the -set
+// flags, with later sources taking precedence.
+// Every setting taken from the environment is logged.
+func (c *configFlags) load() (*Config, error) {
+	config, err := c.merge()
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// args returns command-line arguments which pass the
+// same flags to another command.
+func (c *configFlags) args() []string {
+	var res []string
+	if c.file != "" {
+		res = append(res, "-config", c.file)
+	}
+	for _, set := range c.sets {
+		res = append(res, "-set", set)
+	}
+	return res
+}
+
+// merge is like load, but it does not validate the
+// result.
+func (c *configFlags) merge() (*Config, error) {
+	config := DefaultConfig()
+	if c.file != "" {
+		if err := readConfigFile(c.file, config); err != nil {
+			return nil, fmt.Errorf("config file %s: %s", c.file, err)
+		}
+	}
+
+	settings, err := configToMap(config)
+	if err != nil {
+		return nil, err
+	}
+	for _, envVar := range os.Environ() {
+		name := strings.SplitN(envVar, "=", 2)[0]
+		if key, ok := envSetting(name); ok {
+			if value := os.Getenv(name); value != "" {
+				if err := setConfigValue(settings, key, value); err != nil {
+					return nil, fmt.Errorf("invalid %s environment variable: %s", name, err)
+				}
+				// The environment silently overriding a config
+				// file would be confusing, so say so.
+				log.Printf("Using %s from environment variable %s (deprecated; use -set %s=%s)",
+					key, name, key, value)
+			}
+		}
+	}
+	for _, set := range c.sets {
+		parts := strings.SplitN(set, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid setting %q: expected key=value", set)
+		}
+		if err := setConfigValue(settings, parts[0], parts[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	config = &Config{}
+	if err := mapToConfig(settings, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// settingFlags collects the values of a repeated
+// flag.
+type settingFlags []string
+
+func (s *settingFlags) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *settingFlags) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// readConfigFile reads a config file on top of the
+// existing settings in c, choosing a format based on
+// the file extension.
+func readConfigFile(path string, c *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return decodeConfigJSON(data, c)
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &settings); err != nil {
+			return err
+		}
+		settings = stringKeys(settings)
+	case ".toml":
+		var table map[string]interface{}
+		if _, err := toml.Decode(string(data), &table); err != nil {
+			return err
+		}
+		settings = table
+	default:
+		return errors.New("unknown config file type (expected .json, .yaml or .toml)")
+	}
+	// The settings go through JSON so that every format
+	// uses the same keys and reports unknown ones.
+	data, err = json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return decodeConfigJSON(data, c)
+}
+
+// stringKeys converts the maps produced by the YAML
+// decoder into maps with string keys.
+func stringKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		res := map[string]interface{}{}
+		for k, v := range value {
+			res[fmt.Sprint(k)] = stringKeys(v)
+		}
+		return res
+	case []interface{}:
+		for i, v := range value {
+			value[i] = stringKeys(v)
+		}
+	}
+	return value
+}
+
+func decodeConfigJSON(data []byte, c *Config) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(c)
+}
+
+func configToMap(c *Config) (map[string]interface{}, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var res map[string]interface{}
+	if err := decoder.Decode(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func mapToConfig(settings map[string]interface{}, c *Config) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return decodeConfigJSON(data, c)
+}
+
+// setConfigValue changes a setting, given a dotted
+// key like "neuralnet.step_size" and a string value
+// which is converted to the setting's type.
+func setConfigValue(settings map[string]interface{}, key, value string) error {
+	path := strings.Split(key, ".")
+	for _, name := range path[:len(path)-1] {
+		sub, ok := settings[name].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("unknown setting: %s", key)
+		}
+		settings = sub
+	}
+	name := path[len(path)-1]
+	switch settings[name].(type) {
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false", key)
+		}
+		settings[name] = b
+	case json.Number:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%s must be a number", key)
+		}
+		settings[name] = json.Number(value)
+	case string:
+		settings[name] = value
+	default:
+		return fmt.Errorf("unknown setting: %s", key)
+	}
+	return nil
+}
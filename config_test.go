@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/unixpickle/hn-ranker/hnclass"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"split": "random", "features": {"title_ubiquity": 3, "stem": true},
+		"neuralnet": {"step_size": 0.01, "max_epochs": 5}}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(hnclass.TitleUbiquityEnvVar, "4")
+	t.Setenv(SplitEnvVar, StratifiedSplit)
+
+	flags := &configFlags{file: path, sets: settingFlags{"neuralnet.max_epochs=7",
+		"split=chronological"}}
+	c, err := flags.merge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file overrides the defaults.
+	if !c.Features.Stem || c.NeuralNet.StepSize != 0.01 {
+		t.Errorf("settings from the file were lost: %+v", c)
+	}
+	if c.Features.ContentUbiquity != DefaultConfig().Features.ContentUbiquity {
+		t.Errorf("expected default content ubiquity but got %d", c.Features.ContentUbiquity)
+	}
+
+	// The environment overrides the file.
+	if c.Features.TitleUbiquity != 4 {
+		t.Errorf("expected title ubiquity 4 but got %d", c.Features.TitleUbiquity)
+	}
+
+	// -set overrides both.
+	if c.NeuralNet.MaxEpochs != 7 {
+		t.Errorf("expected 7 max epochs but got %d", c.NeuralNet.MaxEpochs)
+	}
+	if c.Split != ChronologicalSplit {
+		t.Errorf("expected %s split but got %s", ChronologicalSplit, c.Split)
+	}
+}
+
+func TestSetConfigValue(t *testing.T) {
+	flags := &configFlags{sets: settingFlags{
+		"neuralnet.step_size=0.25",
+		"features.stem=true",
+		"split_seed=42",
+		"features.time_zone=UTC",
+	}}
+	c, err := flags.merge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.NeuralNet.StepSize != 0.25 || !c.Features.Stem || c.SplitSeed != 42 ||
+		c.Features.TimeZone != "UTC" {
+		t.Errorf("settings were not applied: %+v", c)
+	}
+
+	bad := []string{
+		"neuralnet.nope=1",
+		"nope.step_size=1",
+		"neuralnet=1",
+		"features.stem=maybe",
+		"neuralnet.step_size=abc",
+		"features.title_ubiquity=1.5",
+		"split_seed",
+	}
+	for _, set := range bad {
+		flags := &configFlags{sets: settingFlags{set}}
+		if _, err := flags.merge(); err == nil {
+			t.Errorf("expected an error for %q", set)
+		}
+	}
+
+	t.Setenv(hnclass.NeuralNetStepSizeEnvVar, "fast")
+	if _, err := (&configFlags{}).merge(); err == nil {
+		t.Error("expected an error for an invalid environment variable")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+
+	"github.com/unixpickle/hn-ranker/hnclass"
+)
+
+const DefaultFoldCount = 5
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "cv",
+		Summary: "Run k-fold cross validation and report the metrics for each fold.",
+		Args:    "<list.json> <post-dir>",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Setup:   setupCrossValidate,
+	})
+}
+
+func setupCrossValidate(flags *flag.FlagSet) func(args []string) error {
+	configFlags := addConfigFlags(flags)
+	foldCount := flags.Int("folds", DefaultFoldCount, "number of folds")
+	parallel := flags.Int("parallel", 0, "number of folds to train at once (0 for all)")
+	format := addFormatFlag(flags)
+	return func(args []string) error {
+		if err := checkFormat(*format); err != nil {
+			return err
+		}
+		config, err := configFlags.load()
+		if err != nil {
+			return err
+		}
+		results, err := CrossValidate(args[0], args[1], config, *foldCount, *parallel)
+		if err != nil {
+			return err
+		}
+		if *format == JSONFormat {
+			return writeJSON(foldSummary(results))
+		}
+		printFoldSummary(results)
+		return nil
+	}
+}
+
+// CrossValidate runs k-fold cross validation, training
+// a new feature map and classifier for every fold and
+// returning the metrics for each one.
+// At most parallel folds are trained at once, or all
+// of them if parallel is 0.
+func CrossValidate(storyList, postDir string, config *Config, foldCount,
+	parallel int) ([]*hnclass.Metrics, error) {
+	if foldCount < 2 {
+		return nil, errors.New("there must be at least two folds")
+	}
+	if parallel <= 0 || parallel > foldCount {
+		parallel = foldCount
+	}
+	if config.NeuralNet.CheckpointDir != "" {
+		return nil, errors.New("neuralnet.checkpoint_dir cannot be used with cv")
+	}
+
+	split, err := getSplitConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	storyData, scores, err := readTrainingData(storyList, postDir, config)
+	if err != nil {
+		return nil, err
+	}
+	if foldCount > len(storyData) {
+		return nil, fmt.Errorf("cannot make %d folds from %d stories", foldCount,
+			len(storyData))
+	}
+	folds, err := split.makeFolds(storyData, scores, foldCount)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*hnclass.Metrics, foldCount)
+	errs := make([]error, foldCount)
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for fold := 0; fold < foldCount; fold++ {
+		wg.Add(1)
+		go func(fold int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			foldData, foldScores, crossCount := split.foldStories(storyData, scores, folds,
+				fold)
+			log.Printf("Fold %d: %d training, %d cross validation", fold+1,
+				len(foldData)-crossCount, crossCount)
+			model, err := trainModel(foldData, foldScores, crossCount, config, false)
+			if err != nil {
+				errs[fold] = err
+				return
+			}
+			results[fold] = model.evaluate()
+			log.Printf("Fold %d done: %s", fold+1, results[fold])
+		}(fold)
+	}
+	wg.Wait()
+
+	for fold, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("fold %d: %s", fold+1, err)
+		}
+	}
+	return results, nil
+}
+
+// A cvSummary is the output of the cv command.
+type cvSummary struct {
+	Folds    []*hnclass.ModelMetrics `json:"folds"`
+	Accuracy spread                  `json:"accuracy"`
+	MacroF1  spread                  `json:"macro_f1"`
+	LogLoss  spread                  `json:"log_loss"`
+}
+
+type spread struct {
+	Mean   float64 `json:"mean"`
+	Stddev float64 `json:"stddev"`
+}
+
+func newSpread(v []float64) spread {
+	return spread{Mean: mean(v), Stddev: stddev(v)}
+}
+
+func foldSummary(results []*hnclass.Metrics) *cvSummary {
+	res := &cvSummary{}
+	accuracies := make([]float64, len(results))
+	f1s := make([]float64, len(results))
+	losses := make([]float64, len(results))
+	for i, m := range results {
+		res.Folds = append(res.Folds, m.Summary())
+		accuracies[i] = m.Accuracy()
+		f1s[i] = m.MacroF1()
+		losses[i] = m.LogLoss
+	}
+	res.Accuracy = newSpread(accuracies)
+	res.MacroF1 = newSpread(f1s)
+	res.LogLoss = newSpread(losses)
+	return res
+}
+
+func printFoldSummary(results []*hnclass.Metrics) {
+	summary := foldSummary(results)
+	for i, m := range summary.Folds {
+		fmt.Printf("Fold %d: accuracy %0.4f, macro-F1 %0.4f, log loss %0.4f (%d stories)\n",
+			i+1, m.Accuracy, m.MacroF1, m.LogLoss, m.Count)
+	}
+	fmt.Printf("Accuracy: %0.4f ± %0.4f\n", summary.Accuracy.Mean, summary.Accuracy.Stddev)
+	fmt.Printf("Macro-F1: %0.4f ± %0.4f\n", summary.MacroF1.Mean, summary.MacroF1.Stddev)
+	fmt.Printf("Log loss: %0.4f ± %0.4f\n", summary.LogLoss.Mean, summary.LogLoss.Stddev)
+}
+
+func mean(v []float64) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += x
+	}
+	return sum / float64(len(v))
+}
+
+// stddev computes the sample standard deviation.
+func stddev(v []float64) float64 {
+	if len(v) < 2 {
+		return 0
+	}
+	m := mean(v)
+	var sum float64
+	for _, x := range v {
+		sum += (x - m) * (x - m)
+	}
+	return math.Sqrt(sum / float64(len(v)-1))
+}
@@ -21,11 +21,19 @@ type Item struct {
 
 type StoryItem struct {
 	Item
+	By    string `json:"by"`
 	Title string `json:"title"`
 	URL   string `json:"url"`
 	Score int    `json:"score"`
 }
 
+type UserItem struct {
+	ID        string  `json:"id"`
+	Created   int64   `json:"created"`
+	Karma     int     `json:"karma"`
+	Submitted []int64 `json:"submitted"`
+}
+
 func FetchStoryItems(beforeTime time.Time) (<-chan *StoryItem, <-chan error) {
 	storyChan := make(chan *StoryItem)
 	errChan := make(chan error, 1)
@@ -102,6 +110,20 @@ func firstItemBeforeTime(t time.Time, maxId int64) (int64, error) {
 	return lowerBound, nil
 }
 
+// FetchUser fetches a user's profile.
+// It returns nil if there is no such user, as is the
+// case for deleted accounts.
+func FetchUser(id string) (*UserItem, error) {
+	var u *UserItem
+	if err := fetchAPIPage("user/"+id+".json", &u); err != nil {
+		return nil, err
+	}
+	if u == nil || u.ID == "" {
+		return nil, nil
+	}
+	return u, nil
+}
+
 func fetchItem(id int64, obj interface{}) error {
 	idStr := "item/" + strconv.FormatInt(id, 10) + ".json"
 	return fetchAPIPage(idStr, obj)
@@ -0,0 +1,118 @@
+package hnclass
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// authorFeaturesSize is the number of features in the
+// author block, not counting the profileCountsSize
+// features which are added when a FeatureMap has
+// AuthorProfileCounts set.
+const (
+	authorFeaturesSize = 4
+	profileCountsSize  = 2
+)
+
+const (
+	karmaLogScale      = 10
+	accountAgeScale    = 10 * 365 * 24 * time.Hour
+	submissionLogScale = 8
+	priorCountLogScale = 5
+	priorScoreLogScale = 5
+)
+
+// AuthorData describes the submitter of a story.
+type AuthorData struct {
+	Name string
+
+	// Karma, Created and Submissions come from the
+	// submitter's user profile.
+	// Karma and Submissions are the values when the
+	// profile was fetched, not when the story was
+	// posted, so they include the story itself and the
+	// points it earned.
+	Karma       int
+	Created     time.Time
+	Submissions int
+
+	// PriorStories and PriorMeanScore describe earlier
+	// stories by the same submitter.
+	// They are filled in by AddAuthorHistory.
+	PriorStories   int
+	PriorMeanScore float64
+}
+
+// AddAuthorHistory sets the prior story statistics of
+// every story's author using a labeled history of
+// stories, such as a training set.
+// Only history stories posted strictly before a given
+// story count towards its statistics, so a story's own
+// score never leaks into its features.
+func AddAuthorHistory(stories, history []*StoryData, historyScores []int) {
+	type pastStory struct {
+		time  time.Time
+		score int
+	}
+	byAuthor := map[string][]pastStory{}
+	for i, s := range history {
+		if s.Author != nil {
+			byAuthor[s.Author.Name] = append(byAuthor[s.Author.Name],
+				pastStory{s.Time, historyScores[i]})
+		}
+	}
+	for _, past := range byAuthor {
+		sort.Slice(past, func(i, j int) bool {
+			return past[i].time.Before(past[j].time)
+		})
+	}
+
+	for _, s := range stories {
+		if s.Author == nil {
+			continue
+		}
+		past := byAuthor[s.Author.Name]
+		count := sort.Search(len(past), func(i int) bool {
+			return !past[i].time.Before(s.Time)
+		})
+		var total int
+		for _, p := range past[:count] {
+			total += p.score
+		}
+		s.Author.PriorStories = count
+		s.Author.PriorMeanScore = 0
+		if count > 0 {
+			s.Author.PriorMeanScore = float64(total) / float64(count)
+		}
+	}
+}
+
+// authorFeatures computes the author block for a
+// story.
+// The first feature flags whether anything is known
+// about the author at all.
+// If profileCounts is set, the author's karma and
+// submission count are added at the end.
+func authorFeatures(s *StoryData, profileCounts bool) []float64 {
+	size := authorFeaturesSize
+	if profileCounts {
+		size += profileCountsSize
+	}
+	res := make([]float64, size)
+	a := s.Author
+	if a == nil {
+		return res
+	}
+	res[0] = 1
+	if age := s.Time.Sub(a.Created); age > 0 {
+		res[1] = math.Min(1, float64(age)/float64(accountAgeScale))
+	}
+	res[2] = math.Log1p(float64(a.PriorStories)) / priorCountLogScale
+	res[3] = math.Log1p(a.PriorMeanScore) / priorScoreLogScale
+	if profileCounts {
+		res[4] = math.Log1p(math.Max(0, float64(a.Karma))) / karmaLogScale
+		res[5] = math.Log1p(float64(a.Submissions)) / submissionLogScale
+	}
+	return res
+}
@@ -0,0 +1,118 @@
+package hnclass
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const quantileBucketsPrefix = "quantiles:"
+
+// ScoreBuckets maps story scores to class indices.
+// Class 0 covers every score below Cutoffs[0], class
+// i covers scores in [Cutoffs[i-1], Cutoffs[i]), and
+// the last class covers everything from the final
+// cutoff up.
+type ScoreBuckets struct {
+	Cutoffs []int
+}
+
+// ParseScoreBuckets creates ScoreBuckets from a spec.
+// The spec is either a comma-separated list of cutoffs
+// (e.g. "2,5,10,50") or "quantiles:N", in which case
+// the cutoffs are the N-quantiles of the given scores.
+func ParseScoreBuckets(spec string, scores []int) (*ScoreBuckets, error) {
+	if strings.HasPrefix(spec, quantileBucketsPrefix) {
+		countStr := strings.TrimPrefix(spec, quantileBucketsPrefix)
+		count, err := strconv.Atoi(countStr)
+		if err != nil || count < 2 {
+			return nil, errors.New("invalid quantile count: " + countStr)
+		}
+		if len(scores) == 0 {
+			return nil, errors.New("no scores to compute quantiles from")
+		}
+		buckets := NewQuantileBuckets(scores, count)
+		if buckets.ClassCount() < 2 {
+			return nil, errors.New("scores are too uniform to split into quantiles")
+		}
+		return buckets, nil
+	}
+
+	var cutoffs []int
+	for _, field := range strings.Split(spec, ",") {
+		cutoff, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, errors.New("invalid score cutoff: " + field)
+		}
+		if cutoff <= 0 || (len(cutoffs) > 0 && cutoff <= cutoffs[len(cutoffs)-1]) {
+			return nil, fmt.Errorf("score cutoffs must be positive and increasing: %s", spec)
+		}
+		cutoffs = append(cutoffs, cutoff)
+	}
+	return &ScoreBuckets{Cutoffs: cutoffs}, nil
+}
+
+// NewQuantileBuckets creates ScoreBuckets which split
+// the given scores into count roughly equal groups.
+// Ties may make for fewer than count buckets, since
+// every cutoff must be distinct.
+func NewQuantileBuckets(scores []int, count int) *ScoreBuckets {
+	sorted := make([]int, len(scores))
+	copy(sorted, scores)
+	sort.Ints(sorted)
+
+	var cutoffs []int
+	for i := 1; i < count; i++ {
+		cutoff := sorted[i*len(sorted)/count]
+		if cutoff <= sorted[0] || (len(cutoffs) > 0 && cutoff <= cutoffs[len(cutoffs)-1]) {
+			continue
+		}
+		cutoffs = append(cutoffs, cutoff)
+	}
+	return &ScoreBuckets{Cutoffs: cutoffs}
+}
+
+// ClassCount returns the number of classes, which is
+// one more than the number of cutoffs.
+func (s *ScoreBuckets) ClassCount() int {
+	return len(s.Cutoffs) + 1
+}
+
+// Class returns the class index for a score.
+func (s *ScoreBuckets) Class(score int) int {
+	var class int
+	for _, c := range s.Cutoffs {
+		if score >= c {
+			class++
+		}
+	}
+	return class
+}
+
+// Label returns a human-readable score range for a
+// class, such as "2-4" or "50+".
+func (s *ScoreBuckets) Label(class int) string {
+	var low int
+	if class > 0 {
+		low = s.Cutoffs[class-1]
+	}
+	if class == len(s.Cutoffs) {
+		return strconv.Itoa(low) + "+"
+	}
+	high := s.Cutoffs[class] - 1
+	if high == low {
+		return strconv.Itoa(low)
+	}
+	return strconv.Itoa(low) + "-" + strconv.Itoa(high)
+}
+
+// Labels returns the label of every class, in order.
+func (s *ScoreBuckets) Labels() []string {
+	res := make([]string, s.ClassCount())
+	for i := range res {
+		res[i] = s.Label(i)
+	}
+	return res
+}
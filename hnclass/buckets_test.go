@@ -0,0 +1,80 @@
+package hnclass
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseScoreBuckets(t *testing.T) {
+	buckets, err := ParseScoreBuckets("2, 5,10,50", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(buckets.Cutoffs, []int{2, 5, 10, 50}) {
+		t.Errorf("unexpected cutoffs: %v", buckets.Cutoffs)
+	}
+	if buckets.ClassCount() != 5 {
+		t.Errorf("expected 5 classes but got %d", buckets.ClassCount())
+	}
+
+	for _, spec := range []string{"5,2", "2,2", "0,5", "-1", "2,x", "", "quantiles:1",
+		"quantiles:x"} {
+		if _, err := ParseScoreBuckets(spec, []int{1, 2, 3}); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+	if _, err := ParseScoreBuckets("quantiles:4", nil); err == nil {
+		t.Error("expected an error without scores")
+	}
+	if _, err := ParseScoreBuckets("quantiles:4", []int{3, 3, 3}); err == nil {
+		t.Error("expected an error for identical scores")
+	}
+}
+
+func TestNewQuantileBuckets(t *testing.T) {
+	var scores []int
+	for i := 0; i < 100; i++ {
+		scores = append(scores, i)
+	}
+	buckets := NewQuantileBuckets(scores, 4)
+	if !reflect.DeepEqual(buckets.Cutoffs, []int{25, 50, 75}) {
+		t.Errorf("unexpected cutoffs: %v", buckets.Cutoffs)
+	}
+
+	// Most stories get a score of 1, so most of the
+	// quantiles are the same and must be merged.
+	skewed := make([]int, 100)
+	for i := range skewed {
+		switch {
+		case i < 70:
+			skewed[i] = 1
+		case i < 80:
+			skewed[i] = 3
+		case i < 90:
+			skewed[i] = 10
+		default:
+			skewed[i] = 100
+		}
+	}
+	buckets = NewQuantileBuckets(skewed, 10)
+	if !reflect.DeepEqual(buckets.Cutoffs, []int{3, 10, 100}) {
+		t.Errorf("unexpected cutoffs: %v", buckets.Cutoffs)
+	}
+	if buckets.ClassCount() != 4 {
+		t.Errorf("expected 4 classes but got %d", buckets.ClassCount())
+	}
+}
+
+func TestScoreBucketsClass(t *testing.T) {
+	buckets := &ScoreBuckets{Cutoffs: []int{2, 5, 50}}
+	expected := map[int]int{0: 0, 1: 0, 2: 1, 4: 1, 5: 2, 49: 2, 50: 3, 1000: 3}
+	for score, class := range expected {
+		if actual := buckets.Class(score); actual != class {
+			t.Errorf("score %d: expected class %d but got %d", score, class, actual)
+		}
+	}
+	labels := []string{"0-1", "2-4", "5-49", "50+"}
+	if actual := buckets.Labels(); !reflect.DeepEqual(actual, labels) {
+		t.Errorf("expected labels %v but got %v", labels, actual)
+	}
+}
@@ -9,19 +9,40 @@ type Classifier interface {
 	SerializerType() string
 	Serialize() []byte
 	Classify(vec FeatureVector) int
+
+	// ClassifyProba returns the probability of
+	// each class, in order of class index.
+	ClassifyProba(vec FeatureVector) []float64
 }
 
 type TrainableClassifier interface {
 	Classifier
 	Train(training, crossValidation *TrainingData)
+
+	// TrainUnattended is like Train, but it does not
+	// wait for the user to stop training.
+	// It fails if the classifier is not configured to
+	// stop on its own.
+	TrainUnattended(training, crossValidation *TrainingData) error
 }
 
-type ClassifierMaker func(m *FeatureMap, classCount int) (TrainableClassifier, error)
+// A LinearClassifier scores each class with a weighted
+// sum of the features.
+type LinearClassifier interface {
+	Classifier
+
+	// ClassWeights returns the weight of every feature
+	// for every class, or nil if the classifier is not
+	// configured to be linear.
+	ClassWeights() [][]float64
+}
+
+type ClassifierMaker func(m *FeatureMap, buckets *ScoreBuckets, c *Config) (TrainableClassifier, error)
 type Deserializer func(m *FeatureMap, d []byte) (Classifier, error)
 
 var ClassifierMakers = map[string]ClassifierMaker{
-	"neuralnet": func(m *FeatureMap, cc int) (TrainableClassifier, error) {
-		return NewNeuralNet(m, cc)
+	"neuralnet": func(m *FeatureMap, b *ScoreBuckets, c *Config) (TrainableClassifier, error) {
+		return NewNeuralNet(m, b, &c.NeuralNet)
 	},
 }
 
@@ -29,4 +50,7 @@ var Deserializers = map[string]Deserializer{
 	"neuralnet": func(m *FeatureMap, d []byte) (Classifier, error) {
 		return DeserializeNeuralNet(m, d)
 	},
+	"weakai": func(m *FeatureMap, d []byte) (Classifier, error) {
+		return DeserializeWeakaiNet(m, d)
+	},
 }
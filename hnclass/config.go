@@ -0,0 +1,324 @@
+package hnclass
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Config holds the settings which control how a
+// FeatureMap and classifier are built and trained.
+type Config struct {
+	Features  FeatureConfig   `json:"features"`
+	NeuralNet NeuralNetConfig `json:"neuralnet"`
+}
+
+// DefaultConfig returns the default settings.
+// Note that the neural network has no default size or
+// step size, so these must always be set.
+func DefaultConfig() *Config {
+	return &Config{
+		Features: FeatureConfig{
+			ContentUbiquity: defaultContentUbiquity,
+			TitleUbiquity:   defaultTitleUbiquity,
+			HostUbiquity:    defaultHostUbiquity,
+			PathUbiquity:    defaultPathUbiquity,
+			URLUbiquity:     defaultURLUbiquity,
+
+			Weighting: TermFrequency,
+
+			MinTokenLength: 1,
+
+			TitleWordNGrams:   1,
+			ContentWordNGrams: 1,
+
+			TimeZone: defaultTimeZone,
+
+			EmbeddingWeighting: MeanEmbedding,
+
+			Normalization: MaxAbsNormalization,
+		},
+		NeuralNet: NeuralNetConfig{
+			BatchSize: defaultBatchSize,
+			Momentum:  defaultMomentum,
+		},
+	}
+}
+
+// Validate checks every setting in c.
+func (c *Config) Validate() error {
+	if err := c.Features.Validate(); err != nil {
+		return err
+	}
+	_, err := c.NeuralNet.parse()
+	return err
+}
+
+// FeatureConfig holds the settings for NewFeatureMap.
+// See FeatureMap for what most of them mean.
+type FeatureConfig struct {
+	// ContentUbiquity, TitleUbiquity, HostUbiquity,
+	// PathUbiquity and URLUbiquity are the number of
+	// stories a name must appear in to be included in
+	// the corresponding vocabulary.
+	ContentUbiquity int `json:"content_ubiquity"`
+	TitleUbiquity   int `json:"title_ubiquity"`
+	HostUbiquity    int `json:"host_ubiquity"`
+	PathUbiquity    int `json:"path_ubiquity"`
+	URLUbiquity     int `json:"url_ubiquity"`
+
+	Weighting   string `json:"keyword_weighting"`
+	NormalizeL2 bool   `json:"l2_normalize"`
+
+	NFKC           bool `json:"nfkc"`
+	KeepSymbols    bool `json:"keep_symbols"`
+	StopWords      bool `json:"stop_words"`
+	Stem           bool `json:"stem"`
+	MinTokenLength int  `json:"min_token_length"`
+	MaxTokenLength int  `json:"max_token_length"`
+
+	TitleWordNGrams   int `json:"title_word_ngrams"`
+	TitleCharNGrams   int `json:"title_char_ngrams"`
+	ContentWordNGrams int `json:"content_word_ngrams"`
+	ContentCharNGrams int `json:"content_char_ngrams"`
+
+	HashBuckets int  `json:"hash_buckets"`
+	HashSigned  bool `json:"hash_signed"`
+
+	TitleFeatures       bool `json:"title_features"`
+	URLFeatures         bool `json:"url_features"`
+	AuthorFeatures      bool `json:"author_features"`
+	AuthorProfileCounts bool `json:"author_profile_counts"`
+
+	TimeZone     string `json:"time_zone"`
+	TimeFeatures bool   `json:"time_features"`
+
+	EmbeddingFile      string `json:"embeddings_file"`
+	EmbeddingWeighting string `json:"embeddings_weighting"`
+
+	Normalization   string `json:"normalization"`
+	NormalizeBlocks bool   `json:"normalize_blocks"`
+}
+
+// Validate checks the settings in c.
+func (c *FeatureConfig) Validate() error {
+	counts := []struct {
+		name  string
+		value int
+	}{
+		{"content_ubiquity", c.ContentUbiquity},
+		{"title_ubiquity", c.TitleUbiquity},
+		{"host_ubiquity", c.HostUbiquity},
+		{"path_ubiquity", c.PathUbiquity},
+		{"url_ubiquity", c.URLUbiquity},
+		{"min_token_length", c.MinTokenLength},
+		{"max_token_length", c.MaxTokenLength},
+		{"title_word_ngrams", c.TitleWordNGrams},
+		{"title_char_ngrams", c.TitleCharNGrams},
+		{"content_word_ngrams", c.ContentWordNGrams},
+		{"content_char_ngrams", c.ContentCharNGrams},
+		{"hash_buckets", c.HashBuckets},
+	}
+	for _, count := range counts {
+		if count.value < 0 {
+			return fmt.Errorf("features.%s must not be negative", count.name)
+		}
+	}
+
+	if c.AuthorProfileCounts && !c.AuthorFeatures {
+		return errors.New("features.author_profile_counts requires features.author_features")
+	}
+
+	switch c.Weighting {
+	case TermFrequency, TFIDF, SublinearTFIDF, BM25:
+	default:
+		return fmt.Errorf("unknown features.keyword_weighting: %s", c.Weighting)
+	}
+	switch c.EmbeddingWeighting {
+	case MeanEmbedding, TFIDFEmbedding:
+	default:
+		return fmt.Errorf("unknown features.embeddings_weighting: %s", c.EmbeddingWeighting)
+	}
+	switch c.Normalization {
+	case FixedNormalization, MaxAbsNormalization, StdNormalization, NoNormalization:
+	default:
+		return fmt.Errorf("unknown features.normalization: %s", c.Normalization)
+	}
+	// An empty time zone would leave the hour features
+	// up to the machine's local time.
+	if c.TimeZone == "" {
+		return errors.New("features.time_zone must be set, e.g. to UTC")
+	}
+	if _, err := time.LoadLocation(c.TimeZone); err != nil {
+		return fmt.Errorf("invalid features.time_zone: %s", err)
+	}
+	return nil
+}
+
+func (c *FeatureConfig) tokenizer() Tokenizer {
+	return Tokenizer{
+		NFKC:        c.NFKC,
+		KeepSymbols: c.KeepSymbols,
+		StopWords:   c.StopWords,
+		Stem:        c.Stem,
+		MinLength:   c.MinTokenLength,
+		MaxLength:   c.MaxTokenLength,
+	}
+}
+
+// NeuralNetConfig holds the settings for NeuralNet.
+type NeuralNetConfig struct {
+	// Layers lists the hidden layers, such as
+	// "128:relu,64:tanh", or "none" for a linear
+	// model.
+	// If it is empty, there is one sigmoid layer with
+	// HiddenCount units.
+	Layers      string `json:"layers"`
+	HiddenCount int    `json:"hidden_count"`
+
+	StepSize  float64 `json:"step_size"`
+	BatchSize int     `json:"batch_size"`
+	Optimizer string  `json:"optimizer"`
+	Momentum  float64 `json:"momentum"`
+	Schedule  string  `json:"lr_schedule"`
+
+	// MaxEpochs and Patience stop training after a
+	// number of epochs in total or without progress.
+	// Zero means there is no limit.
+	MaxEpochs     int    `json:"max_epochs"`
+	Patience      int    `json:"patience"`
+	CheckpointDir string `json:"checkpoint_dir"`
+
+	WeightDecay  float64 `json:"weight_decay"`
+	Dropout      float64 `json:"dropout"`
+	InputDropout float64 `json:"input_dropout"`
+	ReportFile   string  `json:"report_file"`
+}
+
+// parse validates c and converts it to the form
+// used during training.
+func (c *NeuralNetConfig) parse() (*neuralNetConfig, error) {
+	var sizes []int
+	var activations []string
+	if c.Layers != "" {
+		var err error
+		sizes, activations, err = parseLayerSpec(c.Layers)
+		if err != nil {
+			return nil, fmt.Errorf("invalid neuralnet.layers: %s", err)
+		}
+	} else if c.HiddenCount > 0 {
+		sizes = []int{c.HiddenCount}
+		activations = []string{sigmoidActivation}
+	} else {
+		return nil, errors.New("missing neuralnet.layers or neuralnet.hidden_count")
+	}
+
+	if c.StepSize <= 0 {
+		return nil, errors.New("neuralnet.step_size must be positive")
+	}
+	if c.BatchSize <= 0 {
+		return nil, errors.New("neuralnet.batch_size must be positive")
+	}
+	if _, err := newOptimizer(c.Optimizer, c.Momentum); err != nil {
+		return nil, fmt.Errorf("invalid neuralnet.optimizer: %s", err)
+	}
+	schedule, err := parseLearningSchedule(c.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid neuralnet.lr_schedule: %s", err)
+	}
+	if c.MaxEpochs < 0 || c.Patience < 0 {
+		return nil, errors.New("neuralnet.max_epochs and neuralnet.patience must not be negative")
+	}
+
+	rates := []struct {
+		name  string
+		value float64
+	}{
+		{"weight_decay", c.WeightDecay},
+		{"dropout", c.Dropout},
+		{"input_dropout", c.InputDropout},
+	}
+	for _, rate := range rates {
+		if rate.value < 0 || rate.value >= 1 {
+			return nil, fmt.Errorf("neuralnet.%s must be in [0, 1)", rate.name)
+		}
+	}
+
+	res := &neuralNetConfig{
+		HiddenSizes:       sizes,
+		HiddenActivations: activations,
+		StepSize:          c.StepSize,
+		BatchSize:         c.BatchSize,
+		Optimizer:         c.Optimizer,
+		Momentum:          c.Momentum,
+		Schedule:          schedule,
+		MaxEpochs:         c.MaxEpochs,
+		Patience:          c.Patience,
+		CheckpointDir:     c.CheckpointDir,
+		WeightDecay:       c.WeightDecay,
+		ReportFile:        c.ReportFile,
+	}
+	if c.Dropout > 0 || c.InputDropout > 0 {
+		res.Dropout = &dropout{HiddenRate: c.Dropout, InputRate: c.InputDropout}
+	}
+	return res, nil
+}
+
+// EnvSettings maps the environment variables which
+// older versions were configured with to the names
+// of the settings they correspond to.
+var EnvSettings = map[string]string{
+	ContentUbiquityEnvVar: "features.content_ubiquity",
+	TitleUbiquityEnvVar:   "features.title_ubiquity",
+	HostUbiquityEnvVar:    "features.host_ubiquity",
+	PathUbiquityEnvVar:    "features.path_ubiquity",
+	URLUbiquityEnvVar:     "features.url_ubiquity",
+
+	WeightingEnvVar:   "features.keyword_weighting",
+	NormalizeL2EnvVar: "features.l2_normalize",
+
+	NFKCEnvVar:           "features.nfkc",
+	KeepSymbolsEnvVar:    "features.keep_symbols",
+	StopWordsEnvVar:      "features.stop_words",
+	StemEnvVar:           "features.stem",
+	MinTokenLengthEnvVar: "features.min_token_length",
+	MaxTokenLengthEnvVar: "features.max_token_length",
+
+	TitleWordNGramsEnvVar:   "features.title_word_ngrams",
+	TitleCharNGramsEnvVar:   "features.title_char_ngrams",
+	ContentWordNGramsEnvVar: "features.content_word_ngrams",
+	ContentCharNGramsEnvVar: "features.content_char_ngrams",
+
+	HashBucketsEnvVar: "features.hash_buckets",
+	HashSignedEnvVar:  "features.hash_signed",
+
+	TitleStructureEnvVar: "features.title_features",
+	URLFeaturesEnvVar:    "features.url_features",
+	AuthorFeaturesEnvVar: "features.author_features",
+
+	TimeZoneEnvVar:     "features.time_zone",
+	TimeFeaturesEnvVar: "features.time_features",
+
+	EmbeddingFileEnvVar:      "features.embeddings_file",
+	EmbeddingWeightingEnvVar: "features.embeddings_weighting",
+
+	NormalizationEnvVar:   "features.normalization",
+	NormalizeBlocksEnvVar: "features.normalize_blocks",
+
+	NeuralNetLayersEnvVar:     "neuralnet.layers",
+	NeuralNetHiddenSizeEnvVar: "neuralnet.hidden_count",
+	NeuralNetStepSizeEnvVar:   "neuralnet.step_size",
+	NeuralNetBatchSizeEnvVar:  "neuralnet.batch_size",
+	NeuralNetOptimizerEnvVar:  "neuralnet.optimizer",
+	NeuralNetMomentumEnvVar:   "neuralnet.momentum",
+	NeuralNetScheduleEnvVar:   "neuralnet.lr_schedule",
+
+	NeuralNetMaxEpochsEnvVar:   "neuralnet.max_epochs",
+	NeuralNetPatienceEnvVar:    "neuralnet.patience",
+	NeuralNetCheckpointsEnvVar: "neuralnet.checkpoint_dir",
+
+	NeuralNetWeightDecayEnvVar:  "neuralnet.weight_decay",
+	NeuralNetDropoutEnvVar:      "neuralnet.dropout",
+	NeuralNetInputDropoutEnvVar: "neuralnet.input_dropout",
+	NeuralNetReportFileEnvVar:   "neuralnet.report_file",
+}
@@ -0,0 +1,211 @@
+package hnclass
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// These are the ways of combining word embeddings
+// into a document embedding.
+const (
+	MeanEmbedding  = "mean"
+	TFIDFEmbedding = "tfidf"
+)
+
+// embeddings maps words to pretrained vectors.
+type embeddings struct {
+	dim     int
+	vectors map[string][]float32
+
+	// hash is the hex SHA-256 of the file the vectors
+	// were read from.
+	hash string
+}
+
+// loadEmbeddings reads the vectors of a map which was
+// made with embeddings, such as a deserialized one.
+// It fails if the file has changed since the map was
+// made.
+func (m *FeatureMap) loadEmbeddings() error {
+	if m.EmbeddingFile == "" || m.embeddings != nil {
+		return nil
+	}
+	e, err := readEmbeddings(m.EmbeddingFile)
+	if err != nil {
+		return err
+	}
+	if e.hash != m.EmbeddingHash {
+		return fmt.Errorf("embeddings file %s has changed since the model was made",
+			m.EmbeddingFile)
+	}
+	m.embeddings = e
+	return nil
+}
+
+// embeddingTokenizer is the tokenizer which splits
+// text into words to look up in the embeddings.
+// Embedding files contain whole words, so the words
+// must not be stemmed.
+func (m *FeatureMap) embeddingTokenizer() Tokenizer {
+	t := m.Tokenizer
+	t.Stem = false
+	return t
+}
+
+// readEmbeddings reads a word2vec binary file if the
+// path ends in ".bin", or a GloVe/fastText text file
+// otherwise.
+func readEmbeddings(path string) (*embeddings, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	r := bufio.NewReader(io.TeeReader(f, h))
+	var res *embeddings
+	if filepath.Ext(path) == ".bin" {
+		res, err = readBinaryEmbeddings(r)
+	} else {
+		res, err = readTextEmbeddings(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Hash whatever follows the vectors, too.
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return nil, err
+	}
+	res.hash = hex.EncodeToString(h.Sum(nil))
+	return res, nil
+}
+
+func readTextEmbeddings(r *bufio.Reader) (*embeddings, error) {
+	res := &embeddings{vectors: map[string][]float32{}}
+	for lineNum := 1; ; lineNum++ {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		fields := strings.Fields(line)
+
+		// fastText files start with a "<count> <dim>"
+		// header, which GloVe files lack.
+		if lineNum == 1 && len(fields) == 2 {
+			if _, err := strconv.Atoi(fields[0]); err == nil {
+				fields = nil
+			}
+		}
+
+		if len(fields) > 1 {
+			if res.dim == 0 {
+				res.dim = len(fields) - 1
+			} else if len(fields)-1 != res.dim {
+				return nil, fmt.Errorf("embeddings line %d: expected %d values but got %d",
+					lineNum, res.dim, len(fields)-1)
+			}
+			vec := make([]float32, res.dim)
+			for i, field := range fields[1:] {
+				x, err := strconv.ParseFloat(field, 32)
+				if err != nil {
+					return nil, fmt.Errorf("embeddings line %d: %s", lineNum, err)
+				}
+				vec[i] = float32(x)
+			}
+			res.vectors[fields[0]] = vec
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+	if res.dim == 0 {
+		return nil, errors.New("no embeddings found")
+	}
+	return res, nil
+}
+
+func readBinaryEmbeddings(r *bufio.Reader) (*embeddings, error) {
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	var count, dim int
+	if _, err := fmt.Sscanf(header, "%d %d", &count, &dim); err != nil || dim <= 0 {
+		return nil, errors.New("invalid word2vec header")
+	}
+
+	res := &embeddings{dim: dim, vectors: make(map[string][]float32, count)}
+	for i := 0; i < count; i++ {
+		word, err := r.ReadString(' ')
+		if err != nil {
+			return nil, err
+		}
+		vec := make([]float32, dim)
+		if err := binary.Read(r, binary.LittleEndian, vec); err != nil {
+			return nil, err
+		}
+		res.vectors[strings.TrimSpace(word)] = vec
+	}
+	return res, nil
+}
+
+// embeddingFeatures computes the embedding block for
+// some text: the weighted mean of the vectors of its
+// words.
+// Words without a vector are skipped, and text with no
+// known words gets a zero vector.
+func (m *FeatureMap) embeddingFeatures(text string, index map[string]int,
+	docFreqs []int) []float64 {
+	res := make([]float64, m.EmbeddingDim)
+	if m.embeddings == nil {
+		return res
+	}
+
+	tokenizer := m.embeddingTokenizer()
+	counts, _ := tokenizer.extractKeywords(text, NGrams{})
+
+	var totalWeight float64
+	for word, count := range counts {
+		vec, ok := m.embeddings.vectors[word]
+		if !ok {
+			continue
+		}
+		weight := float64(count)
+		if m.EmbeddingWeighting == TFIDFEmbedding && m.HashBuckets == 0 {
+			// The vocabulary holds stemmed keywords when
+			// stemming is on.
+			keyword := word
+			if m.Tokenizer.Stem {
+				keyword = porterStem(word)
+			}
+			var docFreq int
+			if i, ok := index[keyword]; ok {
+				docFreq = docFreqs[i]
+			}
+			weight *= m.idf(docFreq)
+		}
+		for i, x := range vec {
+			res[i] += weight * float64(x)
+		}
+		totalWeight += weight
+	}
+	if totalWeight > 0 {
+		for i := range res {
+			res[i] /= totalWeight
+		}
+	}
+	return res
+}
@@ -5,6 +5,55 @@ const (
 	TitleUbiquityEnvVar   = "HN_TITLE_UBIQUITY"
 	HostUbiquityEnvVar    = "HN_HOST_UBIQUITY"
 
+	WeightingEnvVar   = "HN_KEYWORD_WEIGHTING"
+	NormalizeL2EnvVar = "HN_L2_NORMALIZE"
+
+	NFKCEnvVar           = "HN_NFKC"
+	KeepSymbolsEnvVar    = "HN_KEEP_SYMBOLS"
+	StopWordsEnvVar      = "HN_STOP_WORDS"
+	StemEnvVar           = "HN_STEM"
+	MinTokenLengthEnvVar = "HN_MIN_TOKEN_LENGTH"
+	MaxTokenLengthEnvVar = "HN_MAX_TOKEN_LENGTH"
+
+	TitleWordNGramsEnvVar   = "HN_TITLE_WORD_NGRAMS"
+	TitleCharNGramsEnvVar   = "HN_TITLE_CHAR_NGRAMS"
+	ContentWordNGramsEnvVar = "HN_CONTENT_WORD_NGRAMS"
+	ContentCharNGramsEnvVar = "HN_CONTENT_CHAR_NGRAMS"
+
+	HashBucketsEnvVar = "HN_HASH_BUCKETS"
+	HashSignedEnvVar  = "HN_HASH_SIGNED"
+
+	TitleStructureEnvVar = "HN_TITLE_FEATURES"
+
+	URLFeaturesEnvVar  = "HN_URL_FEATURES"
+	PathUbiquityEnvVar = "HN_PATH_UBIQUITY"
+	URLUbiquityEnvVar  = "HN_URL_UBIQUITY"
+
+	AuthorFeaturesEnvVar = "HN_AUTHOR_FEATURES"
+
+	TimeZoneEnvVar     = "HN_TIME_ZONE"
+	TimeFeaturesEnvVar = "HN_TIME_FEATURES"
+
+	EmbeddingFileEnvVar      = "HN_EMBEDDINGS_FILE"
+	EmbeddingWeightingEnvVar = "HN_EMBEDDINGS_WEIGHTING"
+
+	NormalizationEnvVar   = "HN_NORMALIZATION"
+	NormalizeBlocksEnvVar = "HN_NORMALIZE_BLOCKS"
+
 	NeuralNetStepSizeEnvVar   = "NEURALNET_STEP_SIZE"
 	NeuralNetHiddenSizeEnvVar = "NEURALNET_HIDDEN_COUNT"
+	NeuralNetLayersEnvVar     = "NEURALNET_LAYERS"
+	NeuralNetBatchSizeEnvVar  = "NEURALNET_BATCH_SIZE"
+	NeuralNetOptimizerEnvVar  = "NEURALNET_OPTIMIZER"
+	NeuralNetMomentumEnvVar   = "NEURALNET_MOMENTUM"
+	NeuralNetScheduleEnvVar   = "NEURALNET_LR_SCHEDULE"
+
+	NeuralNetMaxEpochsEnvVar   = "NEURALNET_MAX_EPOCHS"
+	NeuralNetPatienceEnvVar    = "NEURALNET_PATIENCE"
+	NeuralNetCheckpointsEnvVar = "NEURALNET_CHECKPOINT_DIR"
+
+	NeuralNetWeightDecayEnvVar  = "NEURALNET_WEIGHT_DECAY"
+	NeuralNetDropoutEnvVar      = "NEURALNET_DROPOUT"
+	NeuralNetInputDropoutEnvVar = "NEURALNET_INPUT_DROPOUT"
+	NeuralNetReportFileEnvVar   = "NEURALNET_REPORT_FILE"
 )
@@ -2,8 +2,10 @@ package hnclass
 
 import (
 	"fmt"
-	"os"
-	"strconv"
+	"math"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"time"
 )
 
@@ -13,13 +15,46 @@ const (
 	defaultHostUbiquity    = 1
 )
 
+// These are the keyword weighting schemes.
+const (
+	// TermFrequency weights a keyword by the fraction
+	// of a document's words which it accounts for.
+	TermFrequency = "tf"
+
+	// TFIDF multiplies the term frequency by the
+	// smoothed inverse document frequency.
+	TFIDF = "tfidf"
+
+	// SublinearTFIDF is like TFIDF, but it uses
+	// 1+log(count) in place of the term frequency.
+	SublinearTFIDF = "sublinear"
+
+	// BM25 uses the Okapi BM25 term weight.
+	BM25 = "bm25"
+)
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
 // StoryData contains the raw data of a story,
 // before it is converter into a feature vector.
 type StoryData struct {
 	Title    string
 	Content  string
+	URL      string
 	HostName string
 	Time     time.Time
+
+	// Author is nil if nothing is known about the
+	// story's submitter.
+	Author *AuthorData
+
+	// FrontPageGap is the time since the last story
+	// to reach the front page, or 0 if unknown.
+	// It is filled in by AddFrontPageGaps.
+	FrontPageGap time.Duration
 }
 
 // A FeatureMap describes how to map data from
@@ -29,37 +64,222 @@ type FeatureMap struct {
 	ContentKeywords []string
 	HostNames       []string
 
+	// Tokenizer splits titles and contents into
+	// keywords.
+	Tokenizer Tokenizer
+
+	// TitleNGrams and ContentNGrams select the n-grams
+	// which are added to the keywords of each block.
+	TitleNGrams   NGrams
+	ContentNGrams NGrams
+
+	// Weighting is the keyword weighting scheme, such
+	// as TermFrequency or BM25.
+	Weighting string
+
+	// NormalizeL2 is true if the title and content
+	// keyword weights are each scaled to unit length.
+	NormalizeL2 bool
+
+	// DocCount is the number of stories the map was
+	// made from.
+	// TitleDocFreqs and ContentDocFreqs give the number
+	// of those stories containing each keyword, in the
+	// same order as TitleKeywords and ContentKeywords.
+	DocCount        int
+	TitleDocFreqs   []int
+	ContentDocFreqs []int
+
+	// AvgTitleLength and AvgContentLength are the mean
+	// number of keywords in titles and contents.
+	AvgTitleLength   float64
+	AvgContentLength float64
+
+	// HashBuckets, if non-zero, enables the hashing
+	// trick: keywords and host names are hashed into
+	// this many buckets per block instead of being
+	// looked up in a vocabulary, so the keyword and
+	// host lists are empty and document frequencies
+	// are kept per bucket.
+	// If HashSigned is set, each name also hashes to a
+	// sign, so that collisions tend to cancel out.
+	HashBuckets int
+	HashSigned  bool
+
+	// TitleStructure enables a block of features which
+	// describe the shape of a title, such as its length
+	// and whether it starts with "Show HN:".
+	TitleStructure bool
+
+	// URLFeatures enables blocks of features derived
+	// from story URLs: the registrable domain (eTLD+1),
+	// path tokens, file extension and top-level domain,
+	// each with its own vocabulary, followed by a fixed
+	// block with the path depth and flags for known
+	// platforms like GitHub and arXiv.
+	URLFeatures bool
+	Domains     []string
+	PathTokens  []string
+	Extensions  []string
+	TLDs        []string
+
+	// AuthorFeatures enables a block describing the
+	// story's submitter; see AuthorData.
+	// AuthorProfileCounts adds the submitter's karma and
+	// submission count to the block.
+	// These are fetched long after the stories were
+	// posted, so they include the points of the very
+	// stories being classified.
+	AuthorFeatures      bool
+	AuthorProfileCounts bool
+
+	// TimeZone is the IANA time zone in which the hour
+	// and weekday features are computed.
+	// It is only empty in maps made before the setting
+	// existed, which use the machine's local time.
+	TimeZone string
+
+	// TimeFeatures enables a block with cyclical (sine
+	// and cosine) encodings of the time of day and day
+	// of the week, weekend and US holiday flags, and
+	// the scaled log of the story's FrontPageGap.
+	TimeFeatures bool
+
+	// EmbeddingFile, if set, is the absolute path of a
+	// pretrained word vectors file whose vectors are
+	// averaged to make dense title and content blocks,
+	// each of size EmbeddingDim.
+	// EmbeddingHash is the SHA-256 of the file, so that
+	// a map is never used with different vectors than
+	// it was made with.
+	// EmbeddingWeighting is MeanEmbedding or
+	// TFIDFEmbedding.
+	EmbeddingFile      string
+	EmbeddingHash      string
+	EmbeddingDim       int
+	EmbeddingWeighting string
+
+	// Normalization is the way raw feature values are
+	// scaled, such as MaxAbsNormalization.
+	// For learned normalizations, FeatureScales holds
+	// the multiplier for every feature, computed from
+	// the stories the map was made from.
+	// If NormalizeBlocks is set, the statistics are
+	// pooled over each block (e.g. all title keywords)
+	// rather than kept per feature.
+	// Offset and Scale are only used for
+	// FixedNormalization.
+	Normalization   string
+	NormalizeBlocks bool
+	FeatureScales   []float64
+
 	Offset float64
 	Scale  float64
+
+	indexLock sync.Mutex
+	index     *featureIndex
+
+	// embeddings holds the vectors from EmbeddingFile
+	// once they are loaded.
+	embeddings *embeddings
+}
+
+// featureIndex maps keywords and host names to their
+// positions in a FeatureMap's lists.
+type featureIndex struct {
+	content map[string]int
+	title   map[string]int
+	host    map[string]int
+
+	domain    map[string]int
+	pathToken map[string]int
+	extension map[string]int
+	tld       map[string]int
+
+	// location is the loaded TimeZone, or nil.
+	location *time.Location
 }
 
 // NewFeatureMap generates a FeatureMap which
 // contains all of the keywords from all of the
 // stories in a list.
-func NewFeatureMap(stories []*StoryData) *FeatureMap {
+func NewFeatureMap(stories []*StoryData, c *FeatureConfig) (*FeatureMap, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
 	seenContentKeywords := map[string]int{}
 	seenTitleKeywords := map[string]int{}
 	seenHostNames := map[string]int{}
+	var totalContentLength, totalTitleLength int
+
+	tokenizer := c.tokenizer()
+	titleNGrams := NGrams{
+		Words: c.TitleWordNGrams,
+		Chars: c.TitleCharNGrams,
+	}
+	contentNGrams := NGrams{
+		Words: c.ContentWordNGrams,
+		Chars: c.ContentCharNGrams,
+	}
+	res := &FeatureMap{
+		HashBuckets: c.HashBuckets,
+		HashSigned:  c.HashSigned,
+		URLFeatures: c.URLFeatures,
+
+		AuthorFeatures:      c.AuthorFeatures,
+		AuthorProfileCounts: c.AuthorProfileCounts,
+
+		TimeZone:     c.TimeZone,
+		TimeFeatures: c.TimeFeatures,
+
+		EmbeddingWeighting: c.EmbeddingWeighting,
+	}
+	if c.EmbeddingFile != "" {
+		path, err := filepath.Abs(c.EmbeddingFile)
+		if err != nil {
+			return nil, err
+		}
+		e, err := readEmbeddings(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load embeddings: %s", err)
+		}
+		res.EmbeddingFile = path
+		res.EmbeddingHash = e.hash
+		res.EmbeddingDim = e.dim
+		res.embeddings = e
+	}
+	contentBucketFreqs := make([]int, res.HashBuckets)
+	titleBucketFreqs := make([]int, res.HashBuckets)
+	urlVocab := newURLVocabulary()
 
 	for _, storyData := range stories {
 		seenHostNames[storyData.HostName]++
-		for keyword := range extractKeywords(storyData.Content) {
+		if res.URLFeatures {
+			urlVocab.add(storyData.URL)
+		}
+		contentCounts, contentLength := tokenizer.extractKeywords(storyData.Content,
+			contentNGrams)
+		for keyword := range contentCounts {
 			seenContentKeywords[keyword]++
 		}
-		for keyword := range extractKeywords(storyData.Title) {
+		titleCounts, titleLength := tokenizer.extractKeywords(storyData.Title, titleNGrams)
+		for keyword := range titleCounts {
 			seenTitleKeywords[keyword]++
 		}
+		totalContentLength += contentLength
+		totalTitleLength += titleLength
+		if res.HashBuckets > 0 {
+			res.countBuckets(contentCounts, contentBucketFreqs)
+			res.countBuckets(titleCounts, titleBucketFreqs)
+		}
 	}
 
 	contentKeywords := make([]string, 0, len(seenContentKeywords))
 	titleKeywords := make([]string, 0, len(seenTitleKeywords))
 	hostNames := make([]string, 0, len(seenHostNames))
 
-	ubiquities := []int{
-		getUbiquity(ContentUbiquityEnvVar, defaultContentUbiquity),
-		getUbiquity(TitleUbiquityEnvVar, defaultTitleUbiquity),
-		getUbiquity(HostUbiquityEnvVar, defaultHostUbiquity),
-	}
+	ubiquities := []int{c.ContentUbiquity, c.TitleUbiquity, c.HostUbiquity}
 	counts := []map[string]int{seenContentKeywords, seenTitleKeywords, seenHostNames}
 	slices := []*[]string{&contentKeywords, &titleKeywords, &hostNames}
 
@@ -72,24 +292,135 @@ func NewFeatureMap(stories []*StoryData) *FeatureMap {
 		}
 	}
 
-	return &FeatureMap{
-		TitleKeywords:   titleKeywords,
-		ContentKeywords: contentKeywords,
-		HostNames:       hostNames,
+	titleDocFreqs := make([]int, len(titleKeywords))
+	for i, word := range titleKeywords {
+		titleDocFreqs[i] = seenTitleKeywords[word]
+	}
+	contentDocFreqs := make([]int, len(contentKeywords))
+	for i, word := range contentKeywords {
+		contentDocFreqs[i] = seenContentKeywords[word]
+	}
+
+	if res.URLFeatures {
+		res.Domains = vocabulary(urlVocab.domains, c.HostUbiquity)
+		res.PathTokens = vocabulary(urlVocab.pathTokens, c.PathUbiquity)
+		res.Extensions = vocabulary(urlVocab.extensions, c.URLUbiquity)
+		res.TLDs = vocabulary(urlVocab.tlds, c.URLUbiquity)
+	}
+
+	if res.HashBuckets > 0 {
+		contentKeywords, titleKeywords, hostNames = nil, nil, nil
+		contentDocFreqs, titleDocFreqs = contentBucketFreqs, titleBucketFreqs
+	}
+
+	var avgTitleLength, avgContentLength float64
+	if len(stories) > 0 {
+		avgTitleLength = float64(totalTitleLength) / float64(len(stories))
+		avgContentLength = float64(totalContentLength) / float64(len(stories))
+	}
+
+	res.TitleKeywords = titleKeywords
+	res.ContentKeywords = contentKeywords
+	res.HostNames = hostNames
+
+	res.Tokenizer = tokenizer
+	res.TitleNGrams = titleNGrams
+	res.ContentNGrams = contentNGrams
 
-		// Computed under the assumption that no keywords
-		// were pruned, or at least that a small fraction
-		// of them were.
-		Offset: -2.5,
-		Scale:  0.4,
+	res.Weighting = c.Weighting
+	res.NormalizeL2 = c.NormalizeL2
+
+	res.DocCount = len(stories)
+	res.TitleDocFreqs = titleDocFreqs
+	res.ContentDocFreqs = contentDocFreqs
+	res.AvgTitleLength = avgTitleLength
+	res.AvgContentLength = avgContentLength
+
+	res.TitleStructure = c.TitleFeatures
+
+	// Computed under the assumption that no keywords
+	// were pruned, or at least that a small fraction
+	// of them were.
+	res.Offset = -2.5
+	res.Scale = 0.4
+
+	res.Normalization = c.Normalization
+	res.NormalizeBlocks = c.NormalizeBlocks
+	res.fitNormalization(stories)
+
+	return res, nil
+}
+
+// vocabulary lists the names which were seen at least
+// minCount times.
+func vocabulary(counts map[string]int, minCount int) []string {
+	var res []string
+	for name, count := range counts {
+		if count >= minCount {
+			res = append(res, name)
+		}
 	}
+	return res
 }
 
 // VectorSize returns the total number of features in
 // the feature vectors created for f.
 // This includes space for date/time features.
 func (f *FeatureMap) VectorSize() int {
-	return len(f.TitleKeywords) + len(f.ContentKeywords) + len(f.HostNames) + 24 + 7
+	return f.titleSize() + f.contentSize() + f.hostSize() + 24 + 7 + f.titleStructureSize() +
+		f.urlSize() + f.authorSize() + f.timeSize() + 2*f.EmbeddingDim
+}
+
+func (f *FeatureMap) timeSize() int {
+	if f.TimeFeatures {
+		return timeFeaturesSize
+	}
+	return 0
+}
+
+func (f *FeatureMap) authorSize() int {
+	if f.AuthorFeatures && f.AuthorProfileCounts {
+		return authorFeaturesSize + profileCountsSize
+	} else if f.AuthorFeatures {
+		return authorFeaturesSize
+	}
+	return 0
+}
+
+func (f *FeatureMap) urlSize() int {
+	if f.URLFeatures {
+		return len(f.Domains) + len(f.PathTokens) + len(f.Extensions) + len(f.TLDs) +
+			urlFlagsSize
+	}
+	return 0
+}
+
+func (f *FeatureMap) titleStructureSize() int {
+	if f.TitleStructure {
+		return titleStructureSize
+	}
+	return 0
+}
+
+func (f *FeatureMap) contentSize() int {
+	if f.HashBuckets > 0 {
+		return f.HashBuckets
+	}
+	return len(f.ContentKeywords)
+}
+
+func (f *FeatureMap) titleSize() int {
+	if f.HashBuckets > 0 {
+		return f.HashBuckets
+	}
+	return len(f.TitleKeywords)
+}
+
+func (f *FeatureMap) hostSize() int {
+	if f.HashBuckets > 0 {
+		return f.HashBuckets
+	}
+	return len(f.HostNames)
 }
 
 type FeatureValue struct {
@@ -105,62 +436,236 @@ type FeatureValue struct {
 // to be 0.
 type FeatureVector []FeatureValue
 
+// NewFeatureVectors generates the FeatureVector of
+// every story, spreading the stories across all CPUs.
+func NewFeatureVectors(data []*StoryData, m *FeatureMap) []FeatureVector {
+	res := make([]FeatureVector, len(data))
+
+	indexChan := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexChan {
+				res[i] = NewFeatureVector(data[i], m)
+			}
+		}()
+	}
+
+	for i := range data {
+		indexChan <- i
+	}
+	close(indexChan)
+
+	wg.Wait()
+
+	return res
+}
+
 // NewFeatureVector generates a FeatureVector which
 // represents the given story data for the given
 // mapping of features, m.
 func NewFeatureVector(data *StoryData, m *FeatureMap) FeatureVector {
+	res := m.rawFeatureVector(data)
+	m.normalize(res)
+	return res
+}
+
+// rawFeatureVector is like NewFeatureVector, but it
+// does not normalize the resulting values.
+func (m *FeatureMap) rawFeatureVector(data *StoryData) FeatureVector {
 	var res FeatureVector
 
-	titleKeywords := extractKeywords(data.Title)
-	contentKeywords := extractKeywords(data.Content)
-	for i, x := range m.ContentKeywords {
-		val, ok := contentKeywords[x]
-		if ok {
-			res = append(res, FeatureValue{i, val})
+	contentCounts, contentLength := m.Tokenizer.extractKeywords(data.Content,
+		m.ContentNGrams)
+	index := m.vocabIndex()
+	res = append(res, m.keywordFeatures(0, index.content, m.ContentDocFreqs,
+		contentCounts, contentLength, m.AvgContentLength)...)
+	startIdx := m.contentSize()
+
+	titleCounts, titleLength := m.Tokenizer.extractKeywords(data.Title,
+		m.TitleNGrams)
+	res = append(res, m.keywordFeatures(startIdx, index.title, m.TitleDocFreqs,
+		titleCounts, titleLength, m.AvgTitleLength)...)
+	startIdx += m.titleSize()
+	if m.HashBuckets > 0 {
+		bucket, sign := m.hashName(data.HostName)
+		res = append(res, FeatureValue{startIdx + bucket, sign})
+	} else if i, ok := index.host[data.HostName]; ok {
+		res = append(res, FeatureValue{startIdx + i, 1})
+	}
+
+	startIdx += m.hostSize()
+
+	localTime := m.localTime(data.Time, index)
+	dayTime := localTime.Hour()
+	res = append(res, FeatureValue{startIdx + dayTime, 1})
+
+	startIdx += 24
+
+	weekTime := int(localTime.Weekday())
+	res = append(res, FeatureValue{startIdx + weekTime, 1})
+
+	startIdx += 7
+
+	if m.TitleStructure {
+		for i, x := range titleStructureFeatures(data.Title) {
+			if x != 0 {
+				res = append(res, FeatureValue{startIdx + i, x})
+			}
 		}
 	}
-	startIdx := len(m.ContentKeywords)
-	for i, x := range m.TitleKeywords {
-		val, ok := titleKeywords[x]
-		if ok {
-			res = append(res, FeatureValue{startIdx + i, val})
+
+	startIdx += m.titleStructureSize()
+
+	if m.URLFeatures {
+		res = append(res, m.urlFeatures(startIdx, data.URL, index)...)
+	}
+
+	startIdx += m.urlSize()
+
+	if m.AuthorFeatures {
+		for i, x := range authorFeatures(data, m.AuthorProfileCounts) {
+			if x != 0 {
+				res = append(res, FeatureValue{startIdx + i, x})
+			}
 		}
 	}
-	startIdx += len(m.TitleKeywords)
-	for i, host := range m.HostNames {
-		if host == data.HostName {
-			res = append(res, FeatureValue{startIdx + i, 1})
-			break
+
+	startIdx += m.authorSize()
+
+	if m.TimeFeatures {
+		for i, x := range timeFeatures(data, localTime) {
+			if x != 0 {
+				res = append(res, FeatureValue{startIdx + i, x})
+			}
 		}
 	}
 
-	startIdx += len(m.HostNames)
+	startIdx += m.timeSize()
 
-	dayTime := data.Time.Hour()
-	res = append(res, FeatureValue{startIdx + dayTime, 1})
+	if m.EmbeddingDim > 0 {
+		titleEmbedding := m.embeddingFeatures(data.Title, index.title, m.TitleDocFreqs)
+		contentEmbedding := m.embeddingFeatures(data.Content, index.content,
+			m.ContentDocFreqs)
+		for i, x := range append(titleEmbedding, contentEmbedding...) {
+			if x != 0 {
+				res = append(res, FeatureValue{startIdx + i, x})
+			}
+		}
+	}
 
-	startIdx += 24
+	return res
+}
 
-	weekTime := int(data.Time.Weekday())
-	res = append(res, FeatureValue{startIdx + weekTime, 1})
+// vocabIndex returns the featureIndex for m,
+// building it if necessary.
+func (m *FeatureMap) vocabIndex() *featureIndex {
+	m.indexLock.Lock()
+	defer m.indexLock.Unlock()
+	if m.index == nil {
+		m.index = &featureIndex{
+			content: listIndex(m.ContentKeywords),
+			title:   listIndex(m.TitleKeywords),
+			host:    listIndex(m.HostNames),
 
-	for i, x := range res {
-		x.Value = (x.Value - m.Offset) * m.Scale
-		res[i] = x
+			domain:    listIndex(m.Domains),
+			pathToken: listIndex(m.PathTokens),
+			extension: listIndex(m.Extensions),
+			tld:       listIndex(m.TLDs),
+		}
+		if m.TimeZone != "" {
+			loc, err := time.LoadLocation(m.TimeZone)
+			if err != nil {
+				loc = time.UTC
+			}
+			m.index.location = loc
+		}
 	}
+	return m.index
+}
+
+// resetIndex discards the featureIndex for m.
+// It must be called whenever m's lists change.
+func (m *FeatureMap) resetIndex() {
+	m.indexLock.Lock()
+	m.index = nil
+	m.indexLock.Unlock()
+}
 
+func listIndex(list []string) map[string]int {
+	res := make(map[string]int, len(list))
+	for i, x := range list {
+		res[x] = i
+	}
 	return res
 }
 
-func getUbiquity(envVar string, defaultVal int) int {
-	if param := os.Getenv(envVar); param == "" {
-		return defaultVal
+// keywordFeatures computes the weights of the keywords
+// from one document for one block of keywords.
+// The cost is proportional to the number of keywords
+// in the document, not the size of the vocabulary.
+func (m *FeatureMap) keywordFeatures(startIdx int, index map[string]int, docFreqs []int,
+	counts map[string]int, length int, avgLength float64) []FeatureValue {
+	var res []FeatureValue
+	if m.HashBuckets > 0 {
+		res = m.hashedKeywordFeatures(startIdx, docFreqs, counts, length, avgLength)
 	} else {
-		res, err := strconv.Atoi(param)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "invalid %s environment variable", envVar)
-			os.Exit(1)
+		for keyword, count := range counts {
+			i, ok := index[keyword]
+			if !ok {
+				continue
+			}
+			// Maps from version 0 models have no document
+			// frequencies, which term frequency doesn't use.
+			var docFreq int
+			if i < len(docFreqs) {
+				docFreq = docFreqs[i]
+			}
+			val := m.keywordWeight(count, length, docFreq, avgLength)
+			res = append(res, FeatureValue{startIdx + i, val})
 		}
-		return res
+		res = sortedFeatures(res)
+	}
+
+	var squareSum float64
+	for _, x := range res {
+		squareSum += x.Value * x.Value
 	}
+	if m.NormalizeL2 && squareSum > 0 {
+		norm := math.Sqrt(squareSum)
+		for i := range res {
+			res[i].Value /= norm
+		}
+	}
+	return res
+}
+
+func (m *FeatureMap) keywordWeight(count, length, docFreq int, avgLength float64) float64 {
+	tf := float64(count) / float64(length)
+	switch m.Weighting {
+	case TFIDF:
+		return tf * m.idf(docFreq)
+	case SublinearTFIDF:
+		return (1 + math.Log(float64(count))) * m.idf(docFreq)
+	case BM25:
+		n := float64(m.DocCount)
+		df := float64(docFreq)
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		lengthNorm := 1 - bm25B
+		if avgLength > 0 {
+			lengthNorm += bm25B * float64(length) / avgLength
+		}
+		c := float64(count)
+		return idf * c * (bm25K1 + 1) / (c + bm25K1*lengthNorm)
+	default:
+		return tf
+	}
+}
+
+// idf computes the smoothed inverse document
+// frequency of a keyword.
+func (m *FeatureMap) idf(docFreq int) float64 {
+	return math.Log(float64(1+m.DocCount)/float64(1+docFreq)) + 1
 }
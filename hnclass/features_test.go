@@ -0,0 +1,126 @@
+package hnclass
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const benchmarkVocabSize = 50000
+
+func TestKeywordFeaturesIndex(t *testing.T) {
+	stories := benchmarkStories(2000, 200)
+	for _, weighting := range []string{TermFrequency, TFIDF, BM25} {
+		c := DefaultConfig().Features
+		c.ContentUbiquity = 1
+		c.Weighting = weighting
+		m, err := NewFeatureMap(stories, &c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index := m.vocabIndex()
+		for _, story := range stories[:50] {
+			counts, length := m.Tokenizer.extractKeywords(story.Content, m.ContentNGrams)
+			expected := linearKeywordFeatures(m, 10, m.ContentKeywords, m.ContentDocFreqs,
+				counts, length, m.AvgContentLength)
+			actual := m.keywordFeatures(10, index.content, m.ContentDocFreqs, counts, length,
+				m.AvgContentLength)
+			if !reflect.DeepEqual(actual, expected) {
+				t.Fatalf("%s: expected %v but got %v", weighting, expected, actual)
+			}
+		}
+	}
+}
+
+func BenchmarkNewFeatureVector(b *testing.B) {
+	stories, m := benchmarkFeatureMap(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewFeatureVector(stories[i%len(stories)], m)
+	}
+}
+
+func BenchmarkNewFeatureVectors(b *testing.B) {
+	stories, m := benchmarkFeatureMap(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewFeatureVectors(stories, m)
+	}
+}
+
+// linearKeywordFeatures is keywordFeatures as it was
+// before the vocabulary index, scanning every keyword
+// in the block.
+func linearKeywordFeatures(m *FeatureMap, startIdx int, keywords []string, docFreqs []int,
+	counts map[string]int, length int, avgLength float64) []FeatureValue {
+	var res []FeatureValue
+	for i, x := range keywords {
+		count, ok := counts[x]
+		if !ok {
+			continue
+		}
+		val := m.keywordWeight(count, length, docFreqs[i], avgLength)
+		res = append(res, FeatureValue{startIdx + i, val})
+	}
+	return res
+}
+
+// benchmarkFeatureMap makes benchmark stories and a
+// FeatureMap whose content vocabulary holds every one
+// of their words.
+func benchmarkFeatureMap(b *testing.B) ([]*StoryData, *FeatureMap) {
+	stories := benchmarkStories(2000, 200)
+	c := DefaultConfig().Features
+	c.ContentUbiquity = 1
+	m, err := NewFeatureMap(stories, &c)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if len(m.ContentKeywords) < benchmarkVocabSize {
+		b.Fatalf("expected %d keywords but got %d", benchmarkVocabSize,
+			len(m.ContentKeywords))
+	}
+	m.vocabIndex()
+	return stories, m
+}
+
+// benchmarkStories makes stories over a vocabulary of
+// benchmarkVocabSize words, every one of which is used
+// by at least one story.
+func benchmarkStories(count, length int) []*StoryData {
+	gen := rand.New(rand.NewSource(1337))
+	perStory := (benchmarkVocabSize + count - 1) / count
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := make([]*StoryData, count)
+	for i := range res {
+		var words []string
+		for j := 0; j < perStory; j++ {
+			words = append(words, benchmarkWord(i*perStory+j))
+		}
+		for len(words) < length {
+			words = append(words, benchmarkWord(gen.Intn(benchmarkVocabSize)))
+		}
+		res[i] = &StoryData{
+			Title:    strings.Join(words[perStory:perStory+8], " "),
+			Content:  strings.Join(words, " "),
+			HostName: benchmarkWord(gen.Intn(100)) + ".com",
+			Time:     start.Add(time.Duration(i) * time.Hour),
+		}
+	}
+	return res
+}
+
+// benchmarkWord spells out i in letters, so that the
+// tokenizer keeps every word whole.
+func benchmarkWord(i int) string {
+	word := []byte{'w'}
+	for {
+		word = append(word, byte('a'+i%26))
+		i /= 26
+		if i == 0 {
+			return string(word)
+		}
+	}
+}
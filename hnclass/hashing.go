@@ -0,0 +1,62 @@
+package hnclass
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// hashName maps a keyword or host name to a bucket
+// and a sign, which is always 1 unless HashSigned
+// is set.
+func (m *FeatureMap) hashName(name string) (bucket int, sign float64) {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	sum := h.Sum64()
+	bucket = int(sum % uint64(m.HashBuckets))
+	sign = 1
+	if m.HashSigned && sum>>63 == 1 {
+		sign = -1
+	}
+	return
+}
+
+// countBuckets adds one to the frequency of every
+// bucket which a document's keywords hash to.
+func (m *FeatureMap) countBuckets(counts map[string]int, freqs []int) {
+	seen := map[int]bool{}
+	for keyword := range counts {
+		bucket, _ := m.hashName(keyword)
+		if !seen[bucket] {
+			seen[bucket] = true
+			freqs[bucket]++
+		}
+	}
+}
+
+// hashedKeywordFeatures is like keywordFeatures, but
+// it hashes keywords into buckets instead of looking
+// them up in a vocabulary.
+// Keywords which share a bucket are summed together.
+func (m *FeatureMap) hashedKeywordFeatures(startIdx int, docFreqs []int,
+	counts map[string]int, length int, avgLength float64) []FeatureValue {
+	sums := map[int]float64{}
+	for keyword, count := range counts {
+		bucket, sign := m.hashName(keyword)
+		sums[bucket] += sign * m.keywordWeight(count, length, docFreqs[bucket], avgLength)
+	}
+
+	res := make([]FeatureValue, 0, len(sums))
+	for bucket, val := range sums {
+		if val != 0 {
+			res = append(res, FeatureValue{startIdx + bucket, val})
+		}
+	}
+	return sortedFeatures(res)
+}
+
+func sortedFeatures(f []FeatureValue) []FeatureValue {
+	sort.Slice(f, func(i, j int) bool {
+		return f[i].Index < f[j].Index
+	})
+	return f
+}
@@ -0,0 +1,108 @@
+package hnclass
+
+import (
+	"fmt"
+	"time"
+)
+
+// These are the names of the feature blocks, in the
+// order they appear in a FeatureVector.
+const (
+	ContentBlock          = "content"
+	TitleBlock            = "title"
+	HostBlock             = "host"
+	HourBlock             = "hour"
+	WeekdayBlock          = "weekday"
+	TitleStructureBlock   = "title_structure"
+	DomainBlock           = "url_domain"
+	PathBlock             = "url_path"
+	ExtensionBlock        = "url_extension"
+	TLDBlock              = "url_tld"
+	URLFlagsBlock         = "url_flags"
+	AuthorBlock           = "author"
+	TimeBlock             = "time"
+	TitleEmbeddingBlock   = "title_embedding"
+	ContentEmbeddingBlock = "content_embedding"
+)
+
+// A FeatureBlock is a range of indices in a
+// FeatureVector which hold one kind of feature.
+type FeatureBlock struct {
+	Name  string
+	Start int
+	Size  int
+}
+
+// FeatureBlocks returns every block of m's feature
+// vectors in order, including disabled blocks with a
+// size of zero.
+func (m *FeatureMap) FeatureBlocks() []FeatureBlock {
+	var urlSizes [5]int
+	if m.URLFeatures {
+		urlSizes = [5]int{len(m.Domains), len(m.PathTokens), len(m.Extensions), len(m.TLDs),
+			urlFlagsSize}
+	}
+	blocks := []FeatureBlock{
+		{Name: ContentBlock, Size: m.contentSize()},
+		{Name: TitleBlock, Size: m.titleSize()},
+		{Name: HostBlock, Size: m.hostSize()},
+		{Name: HourBlock, Size: 24},
+		{Name: WeekdayBlock, Size: 7},
+		{Name: TitleStructureBlock, Size: m.titleStructureSize()},
+		{Name: DomainBlock, Size: urlSizes[0]},
+		{Name: PathBlock, Size: urlSizes[1]},
+		{Name: ExtensionBlock, Size: urlSizes[2]},
+		{Name: TLDBlock, Size: urlSizes[3]},
+		{Name: URLFlagsBlock, Size: urlSizes[4]},
+		{Name: AuthorBlock, Size: m.authorSize()},
+		{Name: TimeBlock, Size: m.timeSize()},
+		{Name: TitleEmbeddingBlock, Size: m.EmbeddingDim},
+		{Name: ContentEmbeddingBlock, Size: m.EmbeddingDim},
+	}
+	var start int
+	for i := range blocks {
+		blocks[i].Start = start
+		start += blocks[i].Size
+	}
+	return blocks
+}
+
+// FeatureName returns the block of a feature and a
+// name for it within the block, such as a keyword.
+// Features without a natural name, including hashed
+// keywords, are named by their offset in the block.
+func (m *FeatureMap) FeatureName(idx int) (block, name string) {
+	for _, b := range m.FeatureBlocks() {
+		if idx < b.Start || idx >= b.Start+b.Size {
+			continue
+		}
+		offset := idx - b.Start
+		var vocab []string
+		switch b.Name {
+		case ContentBlock:
+			vocab = m.ContentKeywords
+		case TitleBlock:
+			vocab = m.TitleKeywords
+		case HostBlock:
+			vocab = m.HostNames
+		case DomainBlock:
+			vocab = m.Domains
+		case PathBlock:
+			vocab = m.PathTokens
+		case ExtensionBlock:
+			vocab = m.Extensions
+		case TLDBlock:
+			vocab = m.TLDs
+		case HourBlock:
+			return b.Name, fmt.Sprintf("%02d:00", offset)
+		case WeekdayBlock:
+			return b.Name, time.Weekday(offset).String()
+		}
+		hashed := m.HashBuckets > 0 && b.Start < m.contentSize()+m.titleSize()+m.hostSize()
+		if !hashed && offset < len(vocab) {
+			return b.Name, vocab[offset]
+		}
+		return b.Name, fmt.Sprintf("#%d", offset)
+	}
+	return "", fmt.Sprintf("#%d", idx)
+}
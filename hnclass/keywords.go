@@ -3,41 +3,183 @@ package hnclass
 import (
 	"strings"
 	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
-func extractKeywords(content string) map[string]float64 {
-	counts := map[string]int{}
-	extractLetterKeywords(content, counts)
+// A Tokenizer describes how text is broken into
+// keywords.
+// The zero value splits text into lowercase runs of
+// letters and keeps every one of them.
+type Tokenizer struct {
+	// NFKC enables Unicode NFKC normalization before
+	// the text is split.
+	NFKC bool
+
+	// KeepSymbols splits text on whitespace instead of
+	// on every non-letter, so that words like "C++",
+	// "GPT-4" and "1.7" survive.
+	// Punctuation at the edges of a word is removed.
+	KeepSymbols bool
+
+	// StopWords enables the removal of common English
+	// words such as "the" and "of".
+	StopWords bool
+
+	// Stem enables Porter stemming of each keyword.
+	Stem bool
+
+	// MinLength and MaxLength bound the number of
+	// letters in a keyword, measured before stemming.
+	// A MaxLength of 0 means there is no upper bound.
+	MinLength int
+	MaxLength int
+}
 
-	var totalCount int
-	for _, count := range counts {
-		totalCount += count
+// charNGramPrefix is put before character n-grams
+// to tell them apart from words.
+const charNGramPrefix = "#"
+
+// NGrams describes the n-grams which are used as
+// keywords in addition to single words.
+type NGrams struct {
+	// Words is the length of the longest word n-gram,
+	// so 2 adds bigrams and 3 adds bigrams and trigrams.
+	// Values below 2 disable word n-grams.
+	Words int
+
+	// Chars is the length of character n-grams, which
+	// are taken from each word padded with a space on
+	// either side.
+	// A value of 0 disables character n-grams.
+	Chars int
+}
+
+// extractKeywords returns the number of times each
+// keyword appears in some content, along with the
+// total number of keywords.
+func (t *Tokenizer) extractKeywords(content string, n NGrams) (counts map[string]int,
+	total int) {
+	if t.NFKC {
+		content = norm.NFKC.String(content)
 	}
 
-	res := map[string]float64{}
-	for word, count := range counts {
-		res[word] = float64(count) / float64(totalCount)
+	var words []string
+	if t.KeepSymbols {
+		words = symbolWords(content)
+	} else {
+		words = letterWords(content)
+	}
+	// Dropped words, such as stop words, are left as
+	// empty gaps so that n-grams only join words which
+	// were next to each other.
+	keywords := make([]string, len(words))
+	for i, word := range words {
+		keywords[i], _ = t.keyword(word)
 	}
 
-	return res
+	counts = map[string]int{}
+	for size := 1; size <= n.Words || size == 1; size++ {
+	GramLoop:
+		for i := 0; i+size <= len(keywords); i++ {
+			for _, keyword := range keywords[i : i+size] {
+				if keyword == "" {
+					continue GramLoop
+				}
+			}
+			counts[strings.Join(keywords[i:i+size], " ")]++
+			total++
+		}
+	}
+	if n.Chars > 0 {
+		for _, keyword := range keywords {
+			if keyword == "" {
+				continue
+			}
+			for _, gram := range charNGrams(keyword, n.Chars) {
+				counts[charNGramPrefix+gram]++
+				total++
+			}
+		}
+	}
+	return
 }
 
-func extractLetterKeywords(content string, res map[string]int) {
+func (t *Tokenizer) keyword(word string) (string, bool) {
+	length := utf8.RuneCountInString(word)
+	if length < t.MinLength || (t.MaxLength > 0 && length > t.MaxLength) {
+		return "", false
+	}
+	word = strings.ToLower(word)
+	if t.StopWords && englishStopWords[word] {
+		return "", false
+	}
+	if t.Stem {
+		word = porterStem(word)
+	}
+	return word, true
+}
+
+func letterWords(content string) []string {
+	var res []string
 	wordStart := 0
 	runes := []rune(content)
 	for i, r := range runes {
 		if !unicode.IsLetter(r) {
 			if i > wordStart {
-				word := string(runes[wordStart:i])
-				res[strings.ToLower(word)]++
-				wordStart = i + 1
-			} else {
-				wordStart = i + 1
+				res = append(res, string(runes[wordStart:i]))
 			}
+			wordStart = i + 1
 		}
 	}
 	if wordStart < len(runes) {
-		word := string(runes[wordStart:])
-		res[strings.ToLower(word)]++
+		res = append(res, string(runes[wordStart:]))
+	}
+	return res
+}
+
+func symbolWords(content string) []string {
+	var res []string
+	for _, field := range strings.Fields(content) {
+		word := strings.TrimFunc(field, isEdgePunct)
+		if word != "" {
+			res = append(res, word)
+		}
+	}
+	return res
+}
+
+func isEdgePunct(r rune) bool {
+	return strings.ContainsRune(".,:;!?\"'`()[]{}<>", r) || unicode.Is(unicode.Pi, r) ||
+		unicode.Is(unicode.Pf, r)
+}
+
+func charNGrams(word string, size int) []string {
+	runes := []rune(" " + word + " ")
+	if len(runes) < size {
+		return nil
+	}
+	res := make([]string, 0, len(runes)-size+1)
+	for i := 0; i+size <= len(runes); i++ {
+		res = append(res, string(runes[i:i+size]))
+	}
+	return res
+}
+
+var englishStopWords = map[string]bool{}
+
+func init() {
+	words := `a about above after again against all am an and any are as at be
+		because been before being below between both but by can could did do does
+		doing down during each few for from further had has have having he her here
+		hers herself him himself his how i if in into is it its itself just me more
+		most my myself no nor not now of off on once only or other our ours
+		ourselves out over own same she should so some such than that the their
+		theirs them themselves then there these they this those through to too
+		under until up very was we were what when where which while who whom why
+		will with would you your yours yourself yourselves`
+	for _, word := range strings.Fields(words) {
+		englishStopWords[word] = true
 	}
 }
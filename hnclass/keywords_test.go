@@ -0,0 +1,30 @@
+package hnclass
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractKeywordsNGrams(t *testing.T) {
+	tok := Tokenizer{StopWords: true, MinLength: 2}
+	counts, total := tok.extractKeywords("The state of a art, and state of the art",
+		NGrams{Words: 2})
+	expected := map[string]int{"state": 2, "art": 2}
+	if !reflect.DeepEqual(counts, expected) || total != 4 {
+		t.Errorf("expected %v (4 total) but got %v (%d total)", expected, counts, total)
+	}
+
+	counts, total = tok.extractKeywords("machine learning for the machine learning crowd",
+		NGrams{Words: 3})
+	expected = map[string]int{
+		"machine":                2,
+		"learning":               2,
+		"crowd":                  1,
+		"machine learning":       2,
+		"learning crowd":         1,
+		"machine learning crowd": 1,
+	}
+	if !reflect.DeepEqual(counts, expected) || total != 9 {
+		t.Errorf("expected %v (9 total) but got %v (%d total)", expected, counts, total)
+	}
+}
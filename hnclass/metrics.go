@@ -0,0 +1,123 @@
+package hnclass
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// minProbability bounds the probabilities used to
+// compute log loss, so that one confident mistake
+// cannot make the loss infinite.
+const minProbability = 1e-15
+
+// Metrics summarizes how well a classifier does on
+// a set of labeled feature vectors.
+type Metrics struct {
+	Right int
+	Total int
+
+	// ClassRight and ClassTotal are indexed by the
+	// true class of each sample.
+	ClassRight []int
+	ClassTotal []int
+
+	// ClassPredicted counts the samples which were
+	// assigned to each class.
+	ClassPredicted []int
+
+	LogLoss float64
+}
+
+// Evaluate runs a classifier on some data and
+// computes Metrics for it.
+func Evaluate(c Classifier, data *TrainingData, classCount int) *Metrics {
+	res := &Metrics{
+		ClassRight:     make([]int, classCount),
+		ClassTotal:     make([]int, classCount),
+		ClassPredicted: make([]int, classCount),
+	}
+	for i, vec := range data.Vectors {
+		class := data.Classes[i]
+		probs := c.ClassifyProba(vec)
+		predicted := argmax(probs)
+		res.ClassPredicted[predicted]++
+		if predicted == class {
+			res.ClassRight[class]++
+			res.Right++
+		}
+		res.ClassTotal[class]++
+		res.Total++
+		res.LogLoss -= math.Log(math.Max(probs[class], minProbability))
+	}
+	if res.Total > 0 {
+		res.LogLoss /= float64(res.Total)
+	}
+	return res
+}
+
+// ModelMetrics is the summary of Metrics which is
+// saved with a model.
+type ModelMetrics struct {
+	Accuracy float64 `json:"accuracy"`
+	MacroF1  float64 `json:"macro_f1"`
+	LogLoss  float64 `json:"log_loss"`
+	Count    int     `json:"count"`
+}
+
+// Summary returns the ModelMetrics for m.
+func (m *Metrics) Summary() *ModelMetrics {
+	return &ModelMetrics{
+		Accuracy: m.Accuracy(),
+		MacroF1:  m.MacroF1(),
+		LogLoss:  m.LogLoss,
+		Count:    m.Total,
+	}
+}
+
+// Accuracy returns the fraction of correctly
+// classified samples, or 0 if there are none.
+func (m *Metrics) Accuracy() float64 {
+	if m.Total == 0 {
+		return 0
+	}
+	return float64(m.Right) / float64(m.Total)
+}
+
+// MacroF1 returns the unweighted mean of the F1
+// score of every class.
+// Classes which never occur and are never predicted
+// are left out of the mean.
+func (m *Metrics) MacroF1() float64 {
+	var sum float64
+	var count int
+	for i, right := range m.ClassRight {
+		if denom := m.ClassTotal[i] + m.ClassPredicted[i]; denom > 0 {
+			sum += 2 * float64(right) / float64(denom)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+func (m *Metrics) String() string {
+	resStrs := make([]string, len(m.ClassRight))
+	for i, right := range m.ClassRight {
+		resStrs[i] = fmt.Sprintf("%d/%d", right, m.ClassTotal[i])
+	}
+	return fmt.Sprintf("%d/%d (classes: %s) log loss %0.4f", m.Right, m.Total,
+		strings.Join(resStrs, " "), m.LogLoss)
+}
+
+func argmax(v []float64) int {
+	var maxIdx int
+	for i, x := range v {
+		if x > v[maxIdx] {
+			maxIdx = i
+		}
+	}
+	return maxIdx
+}
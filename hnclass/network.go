@@ -0,0 +1,490 @@
+package hnclass
+
+import (
+	"math"
+	"math/rand"
+)
+
+const (
+	sigmoidActivation = "sigmoid"
+	tanhActivation    = "tanh"
+	reluActivation    = "relu"
+	softmaxActivation = "softmax"
+)
+
+func isHiddenActivation(name string) bool {
+	return name == sigmoidActivation || name == tanhActivation || name == reluActivation
+}
+
+// A denseLayer is a fully-connected layer.
+// Weights are stored row-major, with one row of
+// InputCount weights per output.
+type denseLayer struct {
+	InputCount  int
+	OutputCount int
+	Activation  string
+
+	Weights []float64
+	Biases  []float64
+}
+
+func newDenseLayer(inputCount, outputCount int, activation string) *denseLayer {
+	return &denseLayer{
+		InputCount:  inputCount,
+		OutputCount: outputCount,
+		Activation:  activation,
+		Weights:     make([]float64, inputCount*outputCount),
+		Biases:      make([]float64, outputCount),
+	}
+}
+
+// randomize initializes the weights to suit the
+// layer's activation: He initialization for ReLU
+// and Glorot initialization for everything else.
+func (d *denseLayer) randomize(gen *rand.Rand) {
+	if d.Activation == reluActivation {
+		stddev := math.Sqrt(2 / float64(d.InputCount))
+		for i := range d.Weights {
+			d.Weights[i] = gen.NormFloat64() * stddev
+		}
+	} else {
+		r := math.Sqrt(6 / float64(d.InputCount+d.OutputCount))
+		for i := range d.Weights {
+			d.Weights[i] = (gen.Float64()*2 - 1) * r
+		}
+	}
+	for i := range d.Biases {
+		d.Biases[i] = 0
+	}
+}
+
+func (d *denseLayer) forward(input []float64) []float64 {
+	output := make([]float64, d.OutputCount)
+	for i := range output {
+		row := d.Weights[i*d.InputCount : (i+1)*d.InputCount]
+		sum := d.Biases[i]
+		for j, x := range input {
+			sum += row[j] * x
+		}
+		output[i] = sum
+	}
+	applyActivation(d.Activation, output)
+	return output
+}
+
+// forwardSparse is like forward, but it only looks
+// at the non-zero entries of the input.
+func (d *denseLayer) forwardSparse(input FeatureVector) []float64 {
+	output := make([]float64, d.OutputCount)
+	for i := range output {
+		row := d.Weights[i*d.InputCount : (i+1)*d.InputCount]
+		sum := d.Biases[i]
+		for _, x := range input {
+			sum += row[x.Index] * x.Value
+		}
+		output[i] = sum
+	}
+	applyActivation(d.Activation, output)
+	return output
+}
+
+// backward adds the gradient of the cost to grad,
+// given the layer's input and the gradient of the
+// cost with respect to the layer's pre-activation
+// sums.
+// It returns the gradient with respect to the input.
+func (d *denseLayer) backward(input, sumGrad []float64, grad *denseLayer) []float64 {
+	inGrad := make([]float64, d.InputCount)
+	for i, g := range sumGrad {
+		if g == 0 {
+			continue
+		}
+		grad.Biases[i] += g
+		row := d.Weights[i*d.InputCount : (i+1)*d.InputCount]
+		gradRow := grad.Weights[i*d.InputCount : (i+1)*d.InputCount]
+		for j, x := range input {
+			gradRow[j] += g * x
+			inGrad[j] += g * row[j]
+		}
+	}
+	return inGrad
+}
+
+// backwardSparse is like backward, but it only
+// touches the weights of non-zero inputs and does
+// not compute an input gradient.
+func (d *denseLayer) backwardSparse(input FeatureVector, sumGrad []float64, grad *denseLayer) {
+	for i, g := range sumGrad {
+		if g == 0 {
+			continue
+		}
+		grad.Biases[i] += g
+		gradRow := grad.Weights[i*d.InputCount : (i+1)*d.InputCount]
+		for _, x := range input {
+			gradRow[x.Index] += g * x.Value
+		}
+	}
+}
+
+// A network is a stack of dense layers whose last
+// layer is a softmax, trained with cross-entropy.
+// The first layer takes a sparse FeatureVector.
+type network struct {
+	Layers []*denseLayer
+
+	// touched lists the inputs whose first-layer
+	// weights may have a non-zero entry, so that
+	// gradients can be summed and cleared without
+	// visiting every input.
+	// It is only used for gradients.
+	touched map[int]bool
+}
+
+func newNetwork(sizes []int, activations []string) *network {
+	n := &network{}
+	for i, activation := range activations {
+		n.Layers = append(n.Layers, newDenseLayer(sizes[i], sizes[i+1], activation))
+	}
+	return n
+}
+
+// zeroGradient creates a gradient for n, which is a
+// network of the same shape with all of its
+// parameters set to zero.
+func (n *network) zeroGradient() *network {
+	res := &network{touched: map[int]bool{}}
+	for _, l := range n.Layers {
+		res.Layers = append(res.Layers, newDenseLayer(l.InputCount, l.OutputCount,
+			l.Activation))
+	}
+	return res
+}
+
+// clone creates a deep copy of n's parameters.
+func (n *network) clone() *network {
+	res := &network{}
+	for _, l := range n.Layers {
+		c := *l
+		c.Weights = append([]float64{}, l.Weights...)
+		c.Biases = append([]float64{}, l.Biases...)
+		res.Layers = append(res.Layers, &c)
+	}
+	return res
+}
+
+// randomize initializes every layer using numbers
+// from gen.
+func (n *network) randomize(gen *rand.Rand) {
+	for _, l := range n.Layers {
+		l.randomize(gen)
+	}
+}
+
+// params returns every parameter slice in n, in a
+// consistent order.
+func (n *network) params() [][]float64 {
+	var res [][]float64
+	for _, l := range n.Layers {
+		res = append(res, l.Weights, l.Biases)
+	}
+	return res
+}
+
+// dropout describes how units are randomly dropped
+// during a training step.
+type dropout struct {
+	InputRate  float64
+	HiddenRate float64
+}
+
+// A forwardPass records the intermediate values of a
+// forward propagation for use in backpropagation.
+type forwardPass struct {
+	input FeatureVector
+
+	// activations are the outputs of every layer.
+	activations [][]float64
+
+	// outputs are the activations after dropout, which
+	// are what the next layer actually receives.
+	outputs [][]float64
+
+	// masks holds the dropout scale of every unit in
+	// each hidden layer, or nil if none were dropped.
+	masks [][]float64
+}
+
+// apply returns the output of the network.
+func (n *network) apply(input FeatureVector) []float64 {
+	pass := n.forward(input, nil, nil)
+	return pass.outputs[len(pass.outputs)-1]
+}
+
+// forward propagates an input through the network.
+// If d is non-nil, units are dropped using r.
+func (n *network) forward(input FeatureVector, d *dropout, r *rand.Rand) *forwardPass {
+	pass := &forwardPass{input: input}
+	if d != nil && d.InputRate > 0 {
+		pass.input = dropFeatures(input, d.InputRate, r)
+	}
+
+	output := n.Layers[0].forwardSparse(pass.input)
+	for i, l := range n.Layers {
+		if i > 0 {
+			output = l.forward(output)
+		}
+		pass.activations = append(pass.activations, output)
+		var mask []float64
+		if d != nil && d.HiddenRate > 0 && i < len(n.Layers)-1 {
+			mask = dropoutMask(len(output), d.HiddenRate, r)
+			masked := make([]float64, len(output))
+			for j, x := range output {
+				masked[j] = x * mask[j]
+			}
+			output = masked
+		}
+		pass.masks = append(pass.masks, mask)
+		pass.outputs = append(pass.outputs, output)
+	}
+	return pass
+}
+
+// backward adds the gradient of the cross-entropy
+// cost for the given class to grad.
+func (n *network) backward(pass *forwardPass, class int, grad *network) {
+	probs := pass.outputs[len(pass.outputs)-1]
+	sumGrad := make([]float64, len(probs))
+	copy(sumGrad, probs)
+	sumGrad[class]--
+
+	for i := len(n.Layers) - 1; i > 0; i-- {
+		inGrad := n.Layers[i].backward(pass.outputs[i-1], sumGrad, grad.Layers[i])
+		if mask := pass.masks[i-1]; mask != nil {
+			for j, m := range mask {
+				inGrad[j] *= m
+			}
+		}
+		activationDeriv(n.Layers[i-1].Activation, pass.activations[i-1], inGrad)
+		sumGrad = inGrad
+	}
+	n.Layers[0].backwardSparse(pass.input, sumGrad, grad.Layers[0])
+	for _, x := range pass.input {
+		grad.touched[x.Index] = true
+	}
+}
+
+// decayWeights multiplies every weight (but not the
+// biases) by 1-amount.
+func (n *network) decayWeights(amount float64) {
+	for _, l := range n.Layers {
+		for i := range l.Weights {
+			l.Weights[i] *= 1 - amount
+		}
+	}
+}
+
+// forEachGradient calls f with the indices, as in
+// params(), of every entry of the gradient n which may
+// be non-zero.
+// For the first layer, only the weights of touched
+// inputs are visited.
+func (n *network) forEachGradient(f func(param, idx int)) {
+	for i, l := range n.Layers {
+		if i == 0 {
+			for input := range n.touched {
+				for row := 0; row < l.OutputCount; row++ {
+					f(0, row*l.InputCount+input)
+				}
+			}
+		} else {
+			for j := range l.Weights {
+				f(2*i, j)
+			}
+		}
+		for j := range l.Biases {
+			f(2*i+1, j)
+		}
+	}
+}
+
+// A lazyDecay applies weight decay to the first layer
+// of a network one input at a time, just before the
+// input's weights are used, so that a step does not
+// have to visit every weight.
+// The result is the same as calling decayWeights
+// after every step, as long as the optimizer leaves
+// the weights of unused inputs alone.
+type lazyDecay struct {
+	// logScale is the log of the total decay so far,
+	// and applied is the part of it which has been
+	// applied to each input.
+	logScale float64
+	applied  []float64
+}
+
+func newLazyDecay(n *network) *lazyDecay {
+	return &lazyDecay{applied: make([]float64, n.Layers[0].InputCount)}
+}
+
+// decay decays the later layers right away, and the
+// first layer lazily.
+func (l *lazyDecay) decay(n *network, amount float64) {
+	for _, layer := range n.Layers[1:] {
+		for i := range layer.Weights {
+			layer.Weights[i] *= 1 - amount
+		}
+	}
+	l.logScale += math.Log(1 - amount)
+}
+
+// catchUp applies the pending decay to the weights of
+// the inputs used by a feature vector.
+func (l *lazyDecay) catchUp(n *network, vec FeatureVector) {
+	for _, x := range vec {
+		l.catchUpInput(n, x.Index)
+	}
+}
+
+// flush applies the pending decay to every input.
+func (l *lazyDecay) flush(n *network) {
+	for i := range l.applied {
+		l.catchUpInput(n, i)
+	}
+}
+
+func (l *lazyDecay) catchUpInput(n *network, input int) {
+	if l.applied[input] == l.logScale {
+		return
+	}
+	scale := math.Exp(l.logScale - l.applied[input])
+	l.applied[input] = l.logScale
+	layer := n.Layers[0]
+	for row := 0; row < layer.OutputCount; row++ {
+		layer.Weights[row*layer.InputCount+input] *= scale
+	}
+}
+
+// addGradient adds the gradient g to the gradient n.
+func (n *network) addGradient(g *network) {
+	for i, l := range n.Layers {
+		src := g.Layers[i]
+		if i == 0 {
+			for idx := range g.touched {
+				for row := 0; row < l.OutputCount; row++ {
+					l.Weights[row*l.InputCount+idx] += src.Weights[row*l.InputCount+idx]
+				}
+				n.touched[idx] = true
+			}
+		} else {
+			for j, x := range src.Weights {
+				l.Weights[j] += x
+			}
+		}
+		for j, x := range src.Biases {
+			l.Biases[j] += x
+		}
+	}
+}
+
+// scaleGradient multiplies the gradient n by s.
+func (n *network) scaleGradient(s float64) {
+	for i, l := range n.Layers {
+		if i == 0 {
+			for idx := range n.touched {
+				for row := 0; row < l.OutputCount; row++ {
+					l.Weights[row*l.InputCount+idx] *= s
+				}
+			}
+		} else {
+			for j := range l.Weights {
+				l.Weights[j] *= s
+			}
+		}
+		for j := range l.Biases {
+			l.Biases[j] *= s
+		}
+	}
+}
+
+// clearGradient sets the gradient n back to zero.
+func (n *network) clearGradient() {
+	n.scaleGradient(0)
+	n.touched = map[int]bool{}
+}
+
+// dropFeatures randomly removes features from a
+// vector, scaling the remaining ones so that the
+// expected value of each feature stays the same.
+func dropFeatures(vec FeatureVector, rate float64, r *rand.Rand) FeatureVector {
+	res := make(FeatureVector, 0, len(vec))
+	for _, x := range vec {
+		if r.Float64() >= rate {
+			res = append(res, FeatureValue{x.Index, x.Value / (1 - rate)})
+		}
+	}
+	return res
+}
+
+// dropoutMask creates a mask which zeroes units with
+// the given probability and scales the rest up to
+// make up for it.
+func dropoutMask(size int, rate float64, r *rand.Rand) []float64 {
+	mask := make([]float64, size)
+	for i := range mask {
+		if r.Float64() >= rate {
+			mask[i] = 1 / (1 - rate)
+		}
+	}
+	return mask
+}
+
+func applyActivation(name string, v []float64) {
+	switch name {
+	case sigmoidActivation:
+		for i, x := range v {
+			v[i] = 1 / (1 + math.Exp(-x))
+		}
+	case tanhActivation:
+		for i, x := range v {
+			v[i] = math.Tanh(x)
+		}
+	case reluActivation:
+		for i, x := range v {
+			v[i] = math.Max(0, x)
+		}
+	case softmaxActivation:
+		max := math.Inf(-1)
+		for _, x := range v {
+			max = math.Max(max, x)
+		}
+		var sum float64
+		for i, x := range v {
+			v[i] = math.Exp(x - max)
+			sum += v[i]
+		}
+		for i := range v {
+			v[i] /= sum
+		}
+	}
+}
+
+// activationDeriv multiplies grad by the derivative
+// of an activation function, given its outputs.
+func activationDeriv(name string, output, grad []float64) {
+	switch name {
+	case sigmoidActivation:
+		for i, o := range output {
+			grad[i] *= o * (1 - o)
+		}
+	case tanhActivation:
+		for i, o := range output {
+			grad[i] *= 1 - o*o
+		}
+	case reluActivation:
+		for i, o := range output {
+			if o <= 0 {
+				grad[i] = 0
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package hnclass
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestNetworkGradient(t *testing.T) {
+	input := FeatureVector{{1, 0.5}, {3, -1.5}, {4, 2}, {8, 0.25}}
+	const class = 2
+	for _, activation := range []string{sigmoidActivation, tanhActivation, reluActivation} {
+		gen := rand.New(rand.NewSource(1337))
+		net := newNetwork([]int{10, 6, 5, 4},
+			[]string{activation, activation, softmaxActivation})
+		net.randomize(gen)
+		for _, l := range net.Layers {
+			for i := range l.Biases {
+				l.Biases[i] = gen.NormFloat64() * 0.1
+			}
+		}
+
+		grad := net.zeroGradient()
+		net.backward(net.forward(input, nil, nil), class, grad)
+
+		cost := func() float64 {
+			return -math.Log(net.apply(input)[class])
+		}
+		const epsilon = 1e-6
+		for i, param := range net.params() {
+			expected := grad.params()[i]
+			for j := range param {
+				old := param[j]
+				param[j] = old + epsilon
+				high := cost()
+				param[j] = old - epsilon
+				low := cost()
+				param[j] = old
+				numerical := (high - low) / (2 * epsilon)
+				if math.Abs(numerical-expected[j]) > 1e-6 {
+					t.Errorf("%s: param %d,%d: expected %f but got %f", activation, i, j,
+						numerical, expected[j])
+				}
+			}
+		}
+	}
+}
+
+func TestLazyDecay(t *testing.T) {
+	gen := rand.New(rand.NewSource(1337))
+	dense := newNetwork([]int{20, 4, 3}, []string{tanhActivation, softmaxActivation})
+	dense.randomize(gen)
+	lazy := dense.clone()
+	decay := newLazyDecay(lazy)
+
+	const amount = 0.01
+	for i := 0; i < 50; i++ {
+		input := FeatureVector{{gen.Intn(10), 1}, {10 + gen.Intn(10), gen.NormFloat64()}}
+		class := gen.Intn(3)
+
+		denseGrad := dense.zeroGradient()
+		dense.backward(dense.forward(input, nil, nil), class, denseGrad)
+		sgdOptimizer{}.step(dense, denseGrad, 0.1)
+		dense.decayWeights(amount)
+
+		decay.catchUp(lazy, input)
+		lazyGrad := lazy.zeroGradient()
+		lazy.backward(lazy.forward(input, nil, nil), class, lazyGrad)
+		sgdOptimizer{}.step(lazy, lazyGrad, 0.1)
+		decay.decay(lazy, amount)
+	}
+	decay.flush(lazy)
+
+	lazyParams := lazy.params()
+	for i, param := range dense.params() {
+		for j, x := range param {
+			if math.Abs(x-lazyParams[i][j]) > 1e-10 {
+				t.Fatalf("param %d,%d: expected %f but got %f", i, j, x, lazyParams[i][j])
+			}
+		}
+	}
+}
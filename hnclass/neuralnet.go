@@ -1,61 +1,67 @@
 package hnclass
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+)
 
-	"github.com/unixpickle/weakai/neuralnet"
+const (
+	defaultBatchSize = 32
+	defaultMomentum  = 0.9
 )
 
+// A NeuralNet is a feedforward network with any
+// number of hidden layers and a softmax output layer,
+// trained to minimize cross-entropy.
 type NeuralNet struct {
 	trainConfig *neuralNetConfig
 
 	featureMap *FeatureMap
-	network    *neuralnet.Network
+	network    *network
+
+	// buckets is only set while training, so that
+	// checkpoints can be saved as complete models.
+	buckets *ScoreBuckets
 }
 
-func NewNeuralNet(m *FeatureMap, classCount int) (*NeuralNet, error) {
-	config, err := getNeuralNetConfig()
+func NewNeuralNet(m *FeatureMap, buckets *ScoreBuckets, c *NeuralNetConfig) (*NeuralNet, error) {
+	config, err := c.parse()
 	if err != nil {
 		return nil, err
 	}
 
-	network, err := neuralnet.NewNetwork([]neuralnet.LayerPrototype{
-		&neuralnet.DenseParams{
-			Activation:  neuralnet.Sigmoid{},
-			InputCount:  m.VectorSize(),
-			OutputCount: config.HiddenCount,
-		},
-		&neuralnet.DenseParams{
-			Activation:  neuralnet.Sigmoid{},
-			InputCount:  config.HiddenCount,
-			OutputCount: classCount,
-		},
-	})
-	if err != nil {
-		return nil, err
-	}
-	network.SetInput(make([]float64, m.VectorSize()))
-	network.SetDownstreamGradient(make([]float64, len(network.Output())))
+	sizes := append([]int{m.VectorSize()}, config.HiddenSizes...)
+	sizes = append(sizes, buckets.ClassCount())
+	activations := append([]string{}, config.HiddenActivations...)
+	activations = append(activations, softmaxActivation)
+	net := newNetwork(sizes, activations)
 
 	return &NeuralNet{
 		trainConfig: config,
 		featureMap:  m,
-		network:     network,
+		network:     net,
+		buckets:     buckets,
 	}, nil
 }
 
 func DeserializeNeuralNet(m *FeatureMap, d []byte) (*NeuralNet, error) {
-	net, err := neuralnet.DeserializeNetwork(d)
-	if err != nil {
+	var net network
+	if err := json.Unmarshal(d, &net); err != nil {
 		return nil, err
 	}
-	return &NeuralNet{featureMap: m, network: net}, nil
+	return &NeuralNet{featureMap: m, network: &net}, nil
 }
 
 func (n *NeuralNet) Train(training, crossValidation *TrainingData) {
@@ -68,12 +74,21 @@ func (n *NeuralNet) Train(training, crossValidation *TrainingData) {
 		fmt.Println("\nCaught interrupt. Ctrl+C again to terminate.")
 		close(killChan)
 	}()
-	log.Println("Press Ctrl+C to finish training.")
+	log.Println("Press Ctrl+C to finish training early.")
 	n.train(training, crossValidation, killChan)
 }
 
+func (n *NeuralNet) TrainUnattended(training, crossValidation *TrainingData) error {
+	if n.trainConfig.MaxEpochs == 0 && n.trainConfig.Patience == 0 {
+		return errors.New("unattended training requires neuralnet.max_epochs or " +
+			"neuralnet.patience")
+	}
+	n.train(training, crossValidation, nil)
+	return nil
+}
+
 func (n *NeuralNet) Serialize() []byte {
-	data, err := n.network.Serialize()
+	data, err := json.Marshal(n.network)
 	if err != nil {
 		panic(err)
 	}
@@ -85,108 +100,318 @@ func (n *NeuralNet) SerializerType() string {
 }
 
 func (n *NeuralNet) Classify(vec FeatureVector) int {
-	inputVec := n.network.Input()
-	for i := range inputVec {
-		inputVec[i] = 0
-	}
-	for _, v := range vec {
-		inputVec[v.Index] = v.Value
-	}
-	n.network.PropagateForward()
-	var outputClass int
-	var maxOutput float64
-	for j, out := range n.network.Output() {
-		if out > maxOutput {
-			maxOutput = out
-			outputClass = j
-		}
-	}
-	return outputClass
+	return argmax(n.ClassifyProba(vec))
+}
+
+func (n *NeuralNet) ClassifyProba(vec FeatureVector) []float64 {
+	return n.network.apply(vec)
 }
 
 func (n *NeuralNet) train(training, crossValidation *TrainingData, cancel <-chan struct{}) {
-	n.network.Randomize()
-	for {
-		crossScores := n.rightCounts(crossValidation.Vectors, crossValidation.Classes)
-		trainScores := n.rightCounts(training.Vectors, training.Classes)
-		log.Printf("Cross validation: %s", crossScores)
-		log.Printf("Training: %s", trainScores)
-
-		perm := rand.Perm(len(training.Vectors))
-		for _, x := range perm {
-			story := training.Vectors[x]
-			class := training.Classes[x]
-			n.sgdStepStory(story, class)
-			select {
-			case <-cancel:
-				return
-			default:
+	n.network.randomize(rand.New(rand.NewSource(rand.Int63())))
+
+	opt, _ := newOptimizer(n.trainConfig.Optimizer, n.trainConfig.Momentum)
+	grads := make([]*network, runtime.GOMAXPROCS(0))
+	for i := range grads {
+		grads[i] = n.network.zeroGradient()
+	}
+
+	// Without a validation set, the best weights
+	// are selected by their training loss instead.
+	selectionData := crossValidation
+	if len(crossValidation.Vectors) == 0 {
+		selectionData = training
+	}
+
+	best := n.network.clone()
+	bestEpoch := 0
+	bestLoss := Evaluate(n, selectionData, n.classCount()).LogLoss
+
+	for epoch := 1; n.trainConfig.MaxEpochs == 0 || epoch <= n.trainConfig.MaxEpochs; epoch++ {
+		cancelled := n.trainEpoch(training, epoch-1, opt, grads, cancel)
+
+		crossMetrics := Evaluate(n, crossValidation, n.classCount())
+		trainMetrics := Evaluate(n, training, n.classCount())
+		log.Printf("Epoch %d validation: %s", epoch, crossMetrics)
+		log.Printf("Epoch %d training: %s", epoch, trainMetrics)
+
+		selectionLoss := crossMetrics.LogLoss
+		if selectionData == training {
+			selectionLoss = trainMetrics.LogLoss
+		}
+		if selectionLoss < bestLoss {
+			best = n.network.clone()
+			bestEpoch = epoch
+			bestLoss = selectionLoss
+		}
+
+		if n.trainConfig.CheckpointDir != "" {
+			if err := n.saveCheckpoint(epoch); err != nil {
+				log.Println("Error saving checkpoint:", err)
 			}
 		}
+
+		if cancelled {
+			break
+		}
+		if n.trainConfig.Patience > 0 && epoch-bestEpoch >= n.trainConfig.Patience {
+			log.Printf("No improvement for %d epochs; stopping early.", n.trainConfig.Patience)
+			break
+		}
 	}
+
+	log.Printf("Restoring weights from epoch %d (log loss %0.4f).", bestEpoch, bestLoss)
+	n.network = best
+
+	n.reportRegularization(training, crossValidation, bestEpoch)
 }
 
-func (n *NeuralNet) sgdStepStory(f FeatureVector, class int) {
-	inputVec := n.network.Input()
-	downstream := n.network.DownstreamGradient()
+// reportRegularization logs the final training and
+// cross validation accuracy next to the settings
+// which produced them.
+// If a report file is configured, a row is appended
+// to it so that runs with different settings can be
+// compared side by side.
+func (n *NeuralNet) reportRegularization(training, crossValidation *TrainingData, epoch int) {
+	trainMetrics := Evaluate(n, training, n.classCount())
 
-	for i := range inputVec {
-		inputVec[i] = 0
+	var d dropout
+	if n.trainConfig.Dropout != nil {
+		d = *n.trainConfig.Dropout
 	}
-	for _, v := range f {
-		inputVec[v.Index] = v.Value
+	fields := []string{
+		strconv.FormatFloat(n.trainConfig.WeightDecay, 'g', -1, 64),
+		strconv.FormatFloat(d.HiddenRate, 'g', -1, 64),
+		strconv.FormatFloat(d.InputRate, 'g', -1, 64),
+		strconv.Itoa(epoch),
+		fmt.Sprintf("%0.4f", trainMetrics.Accuracy()),
+		"",
+		fmt.Sprintf("%0.4f", trainMetrics.LogLoss),
+		"",
 	}
 
-	n.network.PropagateForward()
+	// Without a validation set, the cross columns
+	// are left empty rather than filled with made up
+	// numbers.
+	if len(crossValidation.Vectors) == 0 {
+		log.Printf("Weight decay %s, dropout %s, input dropout %s: training %0.2f%% "+
+			"(no validation set)", fields[0], fields[1], fields[2],
+			100*trainMetrics.Accuracy())
+	} else {
+		crossMetrics := Evaluate(n, crossValidation, n.classCount())
+		fields[5] = fmt.Sprintf("%0.4f", crossMetrics.Accuracy())
+		fields[7] = fmt.Sprintf("%0.4f", crossMetrics.LogLoss)
+		log.Printf("Weight decay %s, dropout %s, input dropout %s: training %0.2f%%, "+
+			"validation %0.2f%% (gap %0.2f%%)", fields[0], fields[1], fields[2],
+			100*trainMetrics.Accuracy(), 100*crossMetrics.Accuracy(),
+			100*(trainMetrics.Accuracy()-crossMetrics.Accuracy()))
+	}
 
-	expected := make([]float64, len(downstream))
-	expected[class] = 1
+	if n.trainConfig.ReportFile == "" {
+		return
+	}
+	if err := appendReportRow(n.trainConfig.ReportFile, fields); err != nil {
+		log.Println("Error writing report:", err)
+	}
+}
 
-	costFunc := neuralnet.MeanSquaredCost{}
-	costFunc.Deriv(n.network, expected, downstream)
+// reportLock serializes writes to report files, since
+// several networks may train at once, as they do for
+// the folds of cross-validation.
+var reportLock sync.Mutex
 
-	n.network.PropagateBackward(false)
-	n.network.StepGradient(-n.trainConfig.StepSize)
+func appendReportRow(path string, fields []string) error {
+	reportLock.Lock()
+	defer reportLock.Unlock()
+
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0755)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var rows []string
+	if os.IsNotExist(statErr) {
+		header := []string{"weight_decay", "dropout", "input_dropout", "epoch",
+			"train_accuracy", "cross_accuracy", "train_log_loss", "cross_log_loss"}
+		rows = append(rows, strings.Join(header, "\t"))
+	}
+	rows = append(rows, strings.Join(fields, "\t"))
+	_, err = f.WriteString(strings.Join(rows, "\n") + "\n")
+	return err
 }
 
-func (n *NeuralNet) rightCounts(vecs []FeatureVector, classes []int) string {
-	rightMap := make([]int, len(n.network.Output()))
-	totalMap := make([]int, len(n.network.Output()))
-	var totalRight int
-	for i, vec := range vecs {
-		output := n.Classify(vec)
-		if output == classes[i] {
-			rightMap[classes[i]]++
-			totalRight++
+// trainEpoch runs one pass over the training data.
+// It returns true if it was cancelled part way.
+func (n *NeuralNet) trainEpoch(training *TrainingData, epoch int, opt optimizer,
+	grads []*network, cancel <-chan struct{}) bool {
+	stepSize := n.trainConfig.StepSize * n.trainConfig.Schedule(epoch)
+	decayAmount := stepSize * n.trainConfig.WeightDecay
+
+	// Plain SGD only changes the weights of the inputs
+	// in each batch, so decay can be applied lazily
+	// and a step does not need to visit every weight.
+	var decay *lazyDecay
+	if _, ok := opt.(sgdOptimizer); ok && decayAmount > 0 && decayAmount < 1 {
+		decay = newLazyDecay(n.network)
+		defer decay.flush(n.network)
+	}
+
+	perm := rand.Perm(len(training.Vectors))
+	for i := 0; i < len(perm); i += n.trainConfig.BatchSize {
+		batchEnd := i + n.trainConfig.BatchSize
+		if batchEnd > len(perm) {
+			batchEnd = len(perm)
+		}
+		batch := perm[i:batchEnd]
+		if decay != nil {
+			for _, idx := range batch {
+				decay.catchUp(n.network, training.Vectors[idx])
+			}
+		}
+		grad := n.batchGradient(training, batch, grads)
+		opt.step(n.network, grad, stepSize)
+		if decay != nil {
+			decay.decay(n.network, decayAmount)
+		} else if decayAmount > 0 {
+			n.network.decayWeights(decayAmount)
+		}
+		select {
+		case <-cancel:
+			return true
+		default:
 		}
-		totalMap[classes[i]]++
 	}
-	resStrs := make([]string, len(rightMap))
-	for i, right := range rightMap {
-		resStrs[i] = fmt.Sprintf("%d/%d", right, totalMap[i])
+	return false
+}
+
+// batchGradient computes the mean gradient over a
+// batch of training samples, splitting the work
+// between goroutines which each use one of grads.
+// The result is stored in grads[0].
+func (n *NeuralNet) batchGradient(data *TrainingData, batch []int, grads []*network) *network {
+	workers := len(grads)
+	if workers > len(batch) {
+		workers = len(batch)
 	}
-	return fmt.Sprintf("%d/%d (classes: %s)", totalRight, len(classes),
-		strings.Join(resStrs, " "))
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			grad := grads[w]
+			grad.clearGradient()
+			r := rand.New(rand.NewSource(rand.Int63()))
+			for i := w; i < len(batch); i += workers {
+				pass := n.network.forward(data.Vectors[batch[i]], n.trainConfig.Dropout, r)
+				n.network.backward(pass, data.Classes[batch[i]], grad)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, g := range grads[1:workers] {
+		grads[0].addGradient(g)
+	}
+	grads[0].scaleGradient(1 / float64(len(batch)))
+	return grads[0]
 }
 
-type neuralNetConfig struct {
-	HiddenCount int
-	StepSize    float64
+func (n *NeuralNet) classCount() int {
+	return n.network.Layers[len(n.network.Layers)-1].OutputCount
 }
 
-func getNeuralNetConfig() (*neuralNetConfig, error) {
-	count, err := strconv.Atoi(os.Getenv(NeuralNetHiddenSizeEnvVar))
-	if err != nil {
-		return nil, fmt.Errorf("missing %s environment variable", NeuralNetHiddenSizeEnvVar)
+// A LayerInfo describes one layer of a NeuralNet.
+type LayerInfo struct {
+	Inputs     int    `json:"inputs"`
+	Outputs    int    `json:"outputs"`
+	Activation string `json:"activation"`
+	Params     int    `json:"params"`
+}
+
+// Layers describes the layers of n, from the input to
+// the output.
+func (n *NeuralNet) Layers() []LayerInfo {
+	var res []LayerInfo
+	for _, l := range n.network.Layers {
+		res = append(res, LayerInfo{
+			Inputs:     l.InputCount,
+			Outputs:    l.OutputCount,
+			Activation: l.Activation,
+			Params:     len(l.Weights) + len(l.Biases),
+		})
 	}
+	return res
+}
 
-	stepSize, err := strconv.ParseFloat(os.Getenv(NeuralNetStepSizeEnvVar), 64)
-	if err != nil {
-		return nil, fmt.Errorf("missing %s environment variable", NeuralNetStepSizeEnvVar)
+// ClassWeights returns the weights of the output layer
+// if n has no hidden layers, or nil otherwise.
+func (n *NeuralNet) ClassWeights() [][]float64 {
+	if len(n.network.Layers) != 1 {
+		return nil
+	}
+	l := n.network.Layers[0]
+	res := make([][]float64, l.OutputCount)
+	for i := range res {
+		res[i] = l.Weights[i*l.InputCount : (i+1)*l.InputCount]
 	}
+	return res
+}
 
-	return &neuralNetConfig{
-		HiddenCount: count,
-		StepSize:    stepSize,
-	}, nil
+// saveCheckpoint writes the current network to the
+// checkpoint directory as a complete model, which can
+// be inspected or used like the final one.
+func (n *NeuralNet) saveCheckpoint(epoch int) error {
+	if err := os.MkdirAll(n.trainConfig.CheckpointDir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("epoch-%04d.model", epoch)
+	data := Serialize(n, n.featureMap, n.buckets, &ModelMetadata{Created: time.Now().UTC()})
+	return ioutil.WriteFile(filepath.Join(n.trainConfig.CheckpointDir, name), data, 0755)
+}
+
+type neuralNetConfig struct {
+	HiddenSizes       []int
+	HiddenActivations []string
+	StepSize          float64
+
+	BatchSize int
+	Optimizer string
+	Momentum  float64
+	Schedule  learningSchedule
+
+	MaxEpochs     int
+	Patience      int
+	CheckpointDir string
+
+	WeightDecay float64
+	Dropout     *dropout
+	ReportFile  string
+}
+
+// parseLayerSpec parses a comma-separated list of
+// hidden layers such as "128:relu,64:tanh".
+// The spec "none" means there are no hidden layers,
+// making the network a linear softmax classifier.
+func parseLayerSpec(spec string) (sizes []int, activations []string, err error) {
+	if spec == "none" {
+		return nil, nil, nil
+	}
+	for _, layer := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(layer), ":")
+		if len(parts) != 2 {
+			return nil, nil, errors.New("invalid layer: " + layer)
+		}
+		size, err := strconv.Atoi(parts[0])
+		if err != nil || size <= 0 {
+			return nil, nil, errors.New("invalid layer size: " + parts[0])
+		}
+		if !isHiddenActivation(parts[1]) {
+			return nil, nil, errors.New("unknown activation: " + parts[1])
+		}
+		sizes = append(sizes, size)
+		activations = append(activations, parts[1])
+	}
+	return
 }
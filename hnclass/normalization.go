@@ -0,0 +1,119 @@
+package hnclass
+
+import "math"
+
+// These are the ways of scaling feature values.
+// None of them shift values, so features which are
+// absent from a story stay zero.
+const (
+	// FixedNormalization applies the map's Offset and
+	// Scale to every non-zero value.
+	FixedNormalization = "fixed"
+
+	// MaxAbsNormalization divides each feature by its
+	// largest absolute value in the training data.
+	MaxAbsNormalization = "maxabs"
+
+	// StdNormalization divides each feature by its
+	// standard deviation in the training data.
+	StdNormalization = "std"
+
+	// NoNormalization leaves feature values as-is.
+	NoNormalization = "none"
+)
+
+// fitNormalization computes m.FeatureScales from the
+// raw feature vectors of some stories.
+// It does nothing unless m.Normalization is
+// MaxAbsNormalization or StdNormalization.
+func (m *FeatureMap) fitNormalization(stories []*StoryData) {
+	if m.Normalization != MaxAbsNormalization && m.Normalization != StdNormalization {
+		m.FeatureScales = nil
+		return
+	}
+
+	size := m.VectorSize()
+	sums := make([]float64, size)
+	sqSums := make([]float64, size)
+	maxAbs := make([]float64, size)
+	for _, story := range stories {
+		for _, x := range m.rawFeatureVector(story) {
+			sums[x.Index] += x.Value
+			sqSums[x.Index] += x.Value * x.Value
+			maxAbs[x.Index] = math.Max(maxAbs[x.Index], math.Abs(x.Value))
+		}
+	}
+
+	counts := make([]float64, size)
+	for i := range counts {
+		counts[i] = float64(len(stories))
+	}
+	if m.NormalizeBlocks {
+		start := 0
+		for _, blockSize := range m.blockSizes() {
+			poolStats(sums[start:start+blockSize], sqSums[start:start+blockSize],
+				maxAbs[start:start+blockSize], counts[start:start+blockSize])
+			start += blockSize
+		}
+	}
+
+	m.FeatureScales = make([]float64, size)
+	for i := range m.FeatureScales {
+		var spread float64
+		if m.Normalization == MaxAbsNormalization {
+			spread = maxAbs[i]
+		} else if counts[i] > 0 {
+			mean := sums[i] / counts[i]
+			spread = math.Sqrt(math.Max(0, sqSums[i]/counts[i]-mean*mean))
+		}
+		if spread == 0 {
+			m.FeatureScales[i] = 1
+		} else {
+			m.FeatureScales[i] = 1 / spread
+		}
+	}
+}
+
+// poolStats replaces the per-feature statistics of a
+// block with statistics for the block as a whole.
+func poolStats(sums, sqSums, maxAbs, counts []float64) {
+	var sum, sqSum, max, count float64
+	for i := range sums {
+		sum += sums[i]
+		sqSum += sqSums[i]
+		max = math.Max(max, maxAbs[i])
+		count += counts[i]
+	}
+	for i := range sums {
+		sums[i], sqSums[i], maxAbs[i], counts[i] = sum, sqSum, max, count
+	}
+}
+
+// normalize scales the values of a raw feature
+// vector in place.
+func (m *FeatureMap) normalize(vec FeatureVector) {
+	switch m.Normalization {
+	case NoNormalization:
+	case MaxAbsNormalization, StdNormalization:
+		for i, x := range vec {
+			vec[i].Value = x.Value * m.FeatureScales[x.Index]
+		}
+	default:
+		// Maps saved before Normalization existed
+		// always used Offset and Scale.
+		for i, x := range vec {
+			vec[i].Value = (x.Value - m.Offset) * m.Scale
+		}
+	}
+}
+
+// blockSizes returns the size of every block of
+// features, in the order they appear in a vector.
+// Blocks which are disabled have size zero.
+func (m *FeatureMap) blockSizes() []int {
+	var res []int
+	for _, block := range m.FeatureBlocks() {
+		res = append(res, block.Size)
+	}
+	return res
+}
@@ -0,0 +1,97 @@
+package hnclass
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFitNormalizationConstantColumns(t *testing.T) {
+	// Every story has the same host and time, so those
+	// columns have no variance, and most hour and
+	// weekday columns are always zero.
+	var stories []*StoryData
+	for i := 0; i < 10; i++ {
+		title := "same title"
+		if i%2 == 0 {
+			title += " golang"
+		}
+		stories = append(stories, &StoryData{
+			Title:    title,
+			Content:  "same content",
+			HostName: "a.com",
+			Time:     time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+		})
+	}
+	for _, method := range []string{MaxAbsNormalization, StdNormalization} {
+		for _, blocks := range []bool{false, true} {
+			c := DefaultConfig().Features
+			c.Normalization = method
+			c.NormalizeBlocks = blocks
+			m, err := NewFeatureMap(stories, &c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.FeatureScales) != m.VectorSize() {
+				t.Fatalf("%s: expected %d scales but got %d", method, m.VectorSize(),
+					len(m.FeatureScales))
+			}
+			for i, scale := range m.FeatureScales {
+				if math.IsNaN(scale) || math.IsInf(scale, 0) || scale <= 0 {
+					t.Errorf("%s (blocks %v): feature %d has scale %f", method, blocks, i,
+						scale)
+				}
+			}
+			for _, s := range stories {
+				for _, x := range NewFeatureVector(s, m) {
+					if math.IsNaN(x.Value) || math.IsInf(x.Value, 0) {
+						t.Errorf("%s (blocks %v): feature %d is %f", method, blocks,
+							x.Index, x.Value)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestNormalizationFromTraining(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	training := []*StoryData{
+		{Title: "go go rust", Content: "go", HostName: "a.com", Time: start},
+		{Title: "rust", Content: "rust", HostName: "b.com", Time: start.Add(time.Hour)},
+	}
+	validation := &StoryData{Title: "go go go go", Content: "go", HostName: "a.com",
+		Time: start.Add(2 * time.Hour)}
+
+	c := DefaultConfig().Features
+	c.Normalization = MaxAbsNormalization
+	m, err := NewFeatureMap(training, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scales := append([]float64{}, m.FeatureScales...)
+
+	raw := m.rawFeatureVector(validation)
+	vec := NewFeatureVector(validation, m)
+	if !reflect.DeepEqual(m.FeatureScales, scales) {
+		t.Error("feature scales changed while making a validation vector")
+	}
+	var sawLarge bool
+	for i, x := range vec {
+		expected := raw[i].Value * scales[raw[i].Index]
+		if x.Index != raw[i].Index || x.Value != expected {
+			t.Errorf("feature %d: expected %f but got %f", x.Index, expected, x.Value)
+		}
+		if x.Value > 1 {
+			sawLarge = true
+		}
+	}
+
+	// The title keyword "go" is more frequent than in
+	// any training story, so it should exceed the
+	// training maximum rather than being rescaled.
+	if !sawLarge {
+		t.Errorf("expected a value above 1 in %v", vec)
+	}
+}
@@ -0,0 +1,162 @@
+package hnclass
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	rmsPropDecay = 0.9
+	adamBeta1    = 0.9
+	adamBeta2    = 0.999
+	optimizerEps = 1e-8
+)
+
+// An optimizer updates a network's parameters given
+// the gradient of the cost.
+type optimizer interface {
+	step(n *network, grad *network, stepSize float64)
+}
+
+func newOptimizer(name string, momentum float64) (optimizer, error) {
+	switch name {
+	case "", "sgd":
+		return sgdOptimizer{}, nil
+	case "momentum":
+		return &momentumOptimizer{momentum: momentum}, nil
+	case "rmsprop":
+		return &rmsPropOptimizer{}, nil
+	case "adam":
+		return &adamOptimizer{}, nil
+	default:
+		return nil, errors.New("unknown optimizer: " + name)
+	}
+}
+
+// sgdOptimizer only visits the parameters which may
+// have a non-zero gradient, so it leaves the weights
+// of unused inputs alone.
+type sgdOptimizer struct{}
+
+func (sgdOptimizer) step(n *network, grad *network, stepSize float64) {
+	params := n.params()
+	gradParams := grad.params()
+	grad.forEachGradient(func(param, idx int) {
+		params[param][idx] -= stepSize * gradParams[param][idx]
+	})
+}
+
+type momentumOptimizer struct {
+	momentum float64
+	velocity [][]float64
+}
+
+func (m *momentumOptimizer) step(n *network, grad *network, stepSize float64) {
+	if m.velocity == nil {
+		m.velocity = zeroParams(n)
+	}
+	gradParams := grad.params()
+	for i, param := range n.params() {
+		v := m.velocity[i]
+		for j, g := range gradParams[i] {
+			v[j] = m.momentum*v[j] + g
+			param[j] -= stepSize * v[j]
+		}
+	}
+}
+
+type rmsPropOptimizer struct {
+	meanSquares [][]float64
+}
+
+func (r *rmsPropOptimizer) step(n *network, grad *network, stepSize float64) {
+	if r.meanSquares == nil {
+		r.meanSquares = zeroParams(n)
+	}
+	gradParams := grad.params()
+	for i, param := range n.params() {
+		s := r.meanSquares[i]
+		for j, g := range gradParams[i] {
+			s[j] = rmsPropDecay*s[j] + (1-rmsPropDecay)*g*g
+			param[j] -= stepSize * g / (math.Sqrt(s[j]) + optimizerEps)
+		}
+	}
+}
+
+type adamOptimizer struct {
+	stepCount int
+	firstMom  [][]float64
+	secondMom [][]float64
+}
+
+func (a *adamOptimizer) step(n *network, grad *network, stepSize float64) {
+	if a.firstMom == nil {
+		a.firstMom = zeroParams(n)
+		a.secondMom = zeroParams(n)
+	}
+	a.stepCount++
+	correction1 := 1 - math.Pow(adamBeta1, float64(a.stepCount))
+	correction2 := 1 - math.Pow(adamBeta2, float64(a.stepCount))
+
+	gradParams := grad.params()
+	for i, param := range n.params() {
+		m, v := a.firstMom[i], a.secondMom[i]
+		for j, g := range gradParams[i] {
+			m[j] = adamBeta1*m[j] + (1-adamBeta1)*g
+			v[j] = adamBeta2*v[j] + (1-adamBeta2)*g*g
+			mHat := m[j] / correction1
+			vHat := v[j] / correction2
+			param[j] -= stepSize * mHat / (math.Sqrt(vHat) + optimizerEps)
+		}
+	}
+}
+
+func zeroParams(n *network) [][]float64 {
+	var res [][]float64
+	for _, p := range n.params() {
+		res = append(res, make([]float64, len(p)))
+	}
+	return res
+}
+
+// A learningSchedule scales the step size based on
+// the current epoch, starting at epoch 0.
+type learningSchedule func(epoch int) float64
+
+// parseLearningSchedule parses a schedule, which is
+// one of "constant", "step:<epochs>:<factor>" (scale
+// by factor every few epochs), or "cosine:<epochs>"
+// (cosine annealing to zero over a number of epochs).
+func parseLearningSchedule(spec string) (learningSchedule, error) {
+	parts := strings.Split(spec, ":")
+	switch {
+	case spec == "" || spec == "constant":
+		return func(epoch int) float64 {
+			return 1
+		}, nil
+	case parts[0] == "step" && len(parts) == 3:
+		every, err1 := strconv.Atoi(parts[1])
+		factor, err2 := strconv.ParseFloat(parts[2], 64)
+		if err1 != nil || err2 != nil || every <= 0 {
+			break
+		}
+		return func(epoch int) float64 {
+			return math.Pow(factor, float64(epoch/every))
+		}, nil
+	case parts[0] == "cosine" && len(parts) == 2:
+		total, err := strconv.Atoi(parts[1])
+		if err != nil || total <= 0 {
+			break
+		}
+		return func(epoch int) float64 {
+			if epoch >= total {
+				return 0
+			}
+			return (1 + math.Cos(math.Pi*float64(epoch)/float64(total))) / 2
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid learning schedule: %s", spec)
+}
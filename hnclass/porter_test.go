@@ -0,0 +1,83 @@
+package hnclass
+
+import "testing"
+
+func TestPorterStem(t *testing.T) {
+	stems := map[string]string{
+		// Step 1a.
+		"caresses": "caress",
+		"ponies":   "poni",
+		"ties":     "ti",
+		"caress":   "caress",
+		"cats":     "cat",
+
+		// Step 1b.
+		"feed":      "feed",
+		"agreed":    "agre",
+		"plastered": "plaster",
+		"bled":      "bled",
+		"motoring":  "motor",
+		"sing":      "sing",
+		"conflated": "conflat",
+		"troubled":  "troubl",
+		"sized":     "size",
+		"hopping":   "hop",
+		"falling":   "fall",
+		"hissing":   "hiss",
+		"filing":    "file",
+
+		// Step 1c.
+		"happy": "happi",
+		"sky":   "sky",
+
+		// Step 2.
+		"relational":      "relat",
+		"conditional":     "condit",
+		"rational":        "ration",
+		"digitizer":       "digit",
+		"vietnamization":  "vietnam",
+		"predication":     "predic",
+		"operator":        "oper",
+		"decisiveness":    "decis",
+		"hopefulness":     "hope",
+		"sensitiviti":     "sensit",
+		"generalizations": "gener",
+
+		// Step 3.
+		"triplicate":  "triplic",
+		"formative":   "form",
+		"formalize":   "formal",
+		"electriciti": "electr",
+		"hopeful":     "hope",
+		"goodness":    "good",
+
+		// Step 4.
+		"revival":     "reviv",
+		"allowance":   "allow",
+		"inference":   "infer",
+		"airliner":    "airlin",
+		"adjustable":  "adjust",
+		"defensible":  "defens",
+		"replacement": "replac",
+		"adoption":    "adopt",
+		"communism":   "commun",
+		"effective":   "effect",
+
+		// Step 5.
+		"probate":  "probat",
+		"rate":     "rate",
+		"cease":    "ceas",
+		"controll": "control",
+		"roll":     "roll",
+
+		// Words which are left alone.
+		"go":    "go",
+		"gpt-4": "gpt-4",
+		"naïve": "naïve",
+	}
+	for word, expected := range stems {
+		if actual := porterStem(word); actual != expected {
+			t.Errorf("%s: expected %s but got %s", word, expected, actual)
+		}
+	}
+}
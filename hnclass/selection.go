@@ -0,0 +1,195 @@
+package hnclass
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// These are the supported feature selection methods.
+const (
+	MutualInformation = "mi"
+	ChiSquared        = "chi2"
+)
+
+// SelectionLimits gives the maximum number of features
+// to keep in each block, where 0 means no limit.
+type SelectionLimits struct {
+	Content int
+	Title   int
+	Host    int
+}
+
+// SelectFeatures prunes the keyword and host blocks
+// of m down to the features which say the most about
+// the class of a story, ranked by mutual information
+// or chi-squared.
+// The stories and classes should only come from the
+// training set, so that the vocabulary doesn't depend
+// on cross validation labels.
+// Any learned normalization is refit on the stories.
+func (m *FeatureMap) SelectFeatures(stories []*StoryData, classes []int, classCount int,
+	method string, limits SelectionLimits) error {
+	if method != MutualInformation && method != ChiSquared {
+		return errors.New("unknown feature selection method: " + method)
+	}
+	if m.HashBuckets > 0 {
+		return errors.New("feature selection does not work with feature hashing")
+	}
+
+	index := m.vocabIndex()
+	contentCounts := newClassCounts(len(m.ContentKeywords), classCount)
+	titleCounts := newClassCounts(len(m.TitleKeywords), classCount)
+	hostCounts := newClassCounts(len(m.HostNames), classCount)
+	classTotals := make([]int, classCount)
+
+	for i, s := range stories {
+		class := classes[i]
+		classTotals[class]++
+		content, _ := m.Tokenizer.extractKeywords(s.Content, m.ContentNGrams)
+		for keyword := range content {
+			if idx, ok := index.content[keyword]; ok {
+				contentCounts[idx][class]++
+			}
+		}
+		title, _ := m.Tokenizer.extractKeywords(s.Title, m.TitleNGrams)
+		for keyword := range title {
+			if idx, ok := index.title[keyword]; ok {
+				titleCounts[idx][class]++
+			}
+		}
+		if idx, ok := index.host[s.HostName]; ok {
+			hostCounts[idx][class]++
+		}
+	}
+
+	keep := topFeatures(contentCounts, classTotals, method, limits.Content)
+	m.ContentKeywords = selectStrings(m.ContentKeywords, keep)
+	m.ContentDocFreqs = selectInts(m.ContentDocFreqs, keep)
+
+	keep = topFeatures(titleCounts, classTotals, method, limits.Title)
+	m.TitleKeywords = selectStrings(m.TitleKeywords, keep)
+	m.TitleDocFreqs = selectInts(m.TitleDocFreqs, keep)
+
+	keep = topFeatures(hostCounts, classTotals, method, limits.Host)
+	m.HostNames = selectStrings(m.HostNames, keep)
+
+	m.resetIndex()
+
+	// Pruning moves features around, so the scales
+	// must be learned again.
+	m.fitNormalization(stories)
+	return nil
+}
+
+// newClassCounts creates a table which counts, for
+// every feature, the stories of each class which have
+// that feature.
+func newClassCounts(featureCount, classCount int) [][]int {
+	res := make([][]int, featureCount)
+	for i := range res {
+		res[i] = make([]int, classCount)
+	}
+	return res
+}
+
+// topFeatures returns the indices of the best limit
+// features, in their original order.
+func topFeatures(counts [][]int, classTotals []int, method string, limit int) []int {
+	indices := make([]int, len(counts))
+	for i := range indices {
+		indices[i] = i
+	}
+	if limit <= 0 || limit >= len(counts) {
+		return indices
+	}
+
+	scores := make([]float64, len(counts))
+	for i, c := range counts {
+		if method == ChiSquared {
+			scores[i] = chiSquared(c, classTotals)
+		} else {
+			scores[i] = mutualInformation(c, classTotals)
+		}
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		return scores[indices[i]] > scores[indices[j]]
+	})
+	indices = indices[:limit]
+	sort.Ints(indices)
+	return indices
+}
+
+// mutualInformation computes the mutual information
+// between a feature's presence and the class, given
+// the number of stories of each class with the feature
+// and the total number of stories of each class.
+func mutualInformation(present, classTotals []int) float64 {
+	n, withFeature := totals(present, classTotals)
+	if withFeature == 0 || withFeature == n {
+		return 0
+	}
+	var res float64
+	for c, total := range classTotals {
+		cells := []struct {
+			joint, marginal float64
+		}{
+			{float64(present[c]), float64(withFeature)},
+			{float64(total - present[c]), float64(n - withFeature)},
+		}
+		for _, cell := range cells {
+			if cell.joint == 0 {
+				continue
+			}
+			res += cell.joint / n * math.Log(cell.joint*n/(cell.marginal*float64(total)))
+		}
+	}
+	return res
+}
+
+// chiSquared computes Pearson's chi-squared statistic
+// for the table of feature presence against class.
+func chiSquared(present, classTotals []int) float64 {
+	n, withFeature := totals(present, classTotals)
+	if withFeature == 0 || withFeature == n {
+		return 0
+	}
+	var res float64
+	for c, total := range classTotals {
+		if total == 0 {
+			continue
+		}
+		expected := float64(total) * withFeature / n
+		diff := float64(present[c]) - expected
+		res += diff * diff / expected
+
+		expected = float64(total) * (n - withFeature) / n
+		diff = float64(total-present[c]) - expected
+		res += diff * diff / expected
+	}
+	return res
+}
+
+func totals(present, classTotals []int) (n, withFeature float64) {
+	for c, total := range classTotals {
+		n += float64(total)
+		withFeature += float64(present[c])
+	}
+	return
+}
+
+func selectStrings(list []string, indices []int) []string {
+	res := make([]string, len(indices))
+	for i, idx := range indices {
+		res[i] = list[idx]
+	}
+	return res
+}
+
+func selectInts(list []int, indices []int) []int {
+	res := make([]int, len(indices))
+	for i, idx := range indices {
+		res[i] = list[idx]
+	}
+	return res
+}
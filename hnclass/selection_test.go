@@ -0,0 +1,75 @@
+package hnclass
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSelectionScores(t *testing.T) {
+	classTotals := []int{10, 10}
+	tests := []struct {
+		present []int
+		mi      float64
+		chi2    float64
+	}{
+		{[]int{8, 2}, 0.8*math.Log(1.6) + 0.2*math.Log(0.4), 7.2},
+		{[]int{2, 8}, 0.8*math.Log(1.6) + 0.2*math.Log(0.4), 7.2},
+		{[]int{10, 0}, math.Log(2), 20},
+		{[]int{5, 5}, 0, 0},
+		{[]int{10, 10}, 0, 0},
+		{[]int{0, 0}, 0, 0},
+	}
+	for _, test := range tests {
+		if mi := mutualInformation(test.present, classTotals); math.Abs(mi-test.mi) > 1e-8 {
+			t.Errorf("%v: expected MI %f but got %f", test.present, test.mi, mi)
+		}
+		if chi2 := chiSquared(test.present, classTotals); math.Abs(chi2-test.chi2) > 1e-8 {
+			t.Errorf("%v: expected chi2 %f but got %f", test.present, test.chi2, chi2)
+		}
+	}
+}
+
+func TestSelectFeatures(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	stories := []*StoryData{
+		{Title: "Rust release", Content: "rust compiler news", HostName: "a.com"},
+		{Title: "Rust tools", Content: "rust tools news", HostName: "a.com"},
+		{Title: "Cooking tips", Content: "cooking news", HostName: "b.com"},
+		{Title: "Garden ideas", Content: "garden news", HostName: "c.com"},
+	}
+	for i, s := range stories {
+		s.Time = start.Add(time.Duration(i) * time.Hour)
+	}
+	classes := []int{1, 1, 0, 0}
+
+	for _, method := range []string{MutualInformation, ChiSquared} {
+		c := DefaultConfig().Features
+		c.ContentUbiquity = 1
+		m, err := NewFeatureMap(stories, &c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		limits := SelectionLimits{Content: 2, Title: 1, Host: 1}
+		if err := m.SelectFeatures(stories, classes, 2, method, limits); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(m.ContentKeywords) != 2 || len(m.ContentDocFreqs) != 2 {
+			t.Errorf("%s: expected 2 content keywords but got %v", method, m.ContentKeywords)
+		} else if idx := m.vocabIndex().content["rust"]; m.ContentKeywords[idx] != "rust" ||
+			m.ContentDocFreqs[idx] != 2 {
+			t.Errorf("%s: expected to keep rust but got %v", method, m.ContentKeywords)
+		}
+		if len(m.TitleKeywords) != 1 || m.TitleKeywords[0] != "rust" {
+			t.Errorf("%s: expected title keywords [rust] but got %v", method, m.TitleKeywords)
+		}
+		if len(m.HostNames) != 1 || m.HostNames[0] != "a.com" {
+			t.Errorf("%s: expected host names [a.com] but got %v", method, m.HostNames)
+		}
+		if len(m.FeatureScales) != m.VectorSize() {
+			t.Errorf("%s: expected %d feature scales but got %d", method, m.VectorSize(),
+				len(m.FeatureScales))
+		}
+	}
+}
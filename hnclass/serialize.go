@@ -6,62 +6,290 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
 )
 
-var errBufferUnderflow = errors.New("buffer underflow")
+// These errors are returned when reading a model file
+// which is damaged.
+var (
+	ErrTruncated = errors.New("model file is truncated")
+	ErrCorrupted = errors.New("model file is corrupted (checksum mismatch)")
+)
 
 var serializeByteOrder = binary.LittleEndian
 
-func Serialize(c Classifier, m *FeatureMap) []byte {
+// modelMagic starts every model file written since
+// formats were versioned.
+const modelMagic = "HNRANKER"
+
+// ModelFormatVersion is the version of the model
+// format written by Serialize.
+//
+// Version 0 models have no header: they are a feature
+// map, a classifier type and classifier data, each but
+// the last preceded by its length.
+// Their classifier is always a weakai network, read as
+// a WeakaiNet, and their score buckets are always
+// baselineCutoffs.
+//
+// Version 2 models start with modelMagic and the
+// version as a uint32, followed by sections for the
+// metadata, feature map, score buckets, classifier
+// type and classifier data, and end with the CRC-32 of
+// everything before it.
+// No released version wrote version 1.
+const ModelFormatVersion = 2
+
+// baselineCutoffs are the score cutoffs of every
+// version 0 model.
+var baselineCutoffs = []int{2, 5, 10, 50}
+
+// ModelMetadata describes how a model was made.
+// Fields which are unknown, as in models migrated from
+// older formats, are left zero.
+type ModelMetadata struct {
+	Created time.Time `json:"created"`
+
+	// DataHash identifies the stories a model was
+	// trained on.
+	DataHash string `json:"data_hash,omitempty"`
+
+	// Config is the configuration used for training,
+	// in whatever form the trainer uses.
+	Config json.RawMessage `json:"config,omitempty"`
+
+	Metrics *ModelMetrics `json:"metrics,omitempty"`
+
+	// Cutoffs and Classifier are filled in by Serialize
+	// and match the rest of the file.
+	Cutoffs    []int  `json:"cutoffs"`
+	Classifier string `json:"classifier"`
+}
+
+// A Model is everything stored in a model file.
+type Model struct {
+	// Version is the format version the model was read
+	// from, which is less than ModelFormatVersion for
+	// older files.
+	Version int
+
+	Metadata   *ModelMetadata
+	Features   *FeatureMap
+	Buckets    *ScoreBuckets
+	Classifier Classifier
+
+	// ClassifierData is the serialized classifier.
+	ClassifierData []byte
+}
+
+// Serialize encodes a model in the latest format.
+// The metadata's Cutoffs and Classifier fields are
+// filled in from the model.
+func Serialize(c Classifier, m *FeatureMap, s *ScoreBuckets, meta *ModelMetadata) []byte {
+	metaCopy := *meta
+	metaCopy.Cutoffs = s.Cutoffs
+	metaCopy.Classifier = c.SerializerType()
 	featureData, _ := json.Marshal(m)
+	return serializeSections(&metaCopy, featureData, s, c.SerializerType(), c.Serialize())
+}
 
-	var b bytes.Buffer
-	binary.Write(&b, serializeByteOrder, uint64(len(featureData)))
-	b.Write(featureData)
+func serializeSections(meta *ModelMetadata, featureData []byte, s *ScoreBuckets,
+	classifierType string, classifierData []byte) []byte {
+	metaData, _ := json.Marshal(meta)
+	bucketData, _ := json.Marshal(s)
 
-	t := []byte(c.SerializerType())
-	binary.Write(&b, serializeByteOrder, uint64(len(t)))
-	b.Write(t)
-	b.Write(c.Serialize())
+	var b bytes.Buffer
+	b.WriteString(modelMagic)
+	binary.Write(&b, serializeByteOrder, uint32(ModelFormatVersion))
+	writeSection(&b, metaData)
+	writeSection(&b, featureData)
+	writeSection(&b, bucketData)
+	writeSection(&b, []byte(classifierType))
+	writeSection(&b, classifierData)
+	binary.Write(&b, serializeByteOrder, crc32.ChecksumIEEE(b.Bytes()))
 
 	return b.Bytes()
 }
 
-func Deserialize(d []byte) (Classifier, *FeatureMap, error) {
-	b := bytes.NewBuffer(d)
-
-	var lenField uint64
-	if err := binary.Read(b, serializeByteOrder, &lenField); err != nil {
-		return nil, nil, err
+// Deserialize decodes a model for classifying stories.
+// Unlike ReadModel, it also loads the feature map's
+// word embeddings, if it has any.
+func Deserialize(d []byte) (Classifier, *FeatureMap, *ScoreBuckets, error) {
+	model, err := ReadModel(d)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := model.Features.loadEmbeddings(); err != nil {
+		return nil, nil, nil, fmt.Errorf("load embeddings: %s", err)
 	}
+	return model.Classifier, model.Features, model.Buckets, nil
+}
 
-	featureData := make([]byte, int(lenField))
-	if n, _ := b.Read(featureData); n < len(featureData) {
-		return nil, nil, errBufferUnderflow
+// ReadModel decodes a model in any format version.
+// It does not load the feature map's word embeddings,
+// so it works without the embeddings file.
+func ReadModel(d []byte) (*Model, error) {
+	model, sections, err := readModelSections(d)
+	if err != nil {
+		return nil, err
 	}
 
 	var features FeatureMap
-	if err := json.Unmarshal(featureData, &features); err != nil {
-		return nil, nil, err
+	if err := json.Unmarshal(sections.features, &features); err != nil {
+		return nil, fmt.Errorf("decode feature map: %s", err)
 	}
+	features.vocabIndex()
+	model.Features = &features
 
-	if err := binary.Read(b, serializeByteOrder, &lenField); err != nil {
-		return nil, nil, err
+	deserializer, ok := Deserializers[model.Metadata.Classifier]
+	if !ok {
+		return nil, fmt.Errorf("unknown classifier type: %s", model.Metadata.Classifier)
 	}
-	nameData := make([]byte, int(lenField))
-	if n, _ := b.Read(nameData); n < len(nameData) {
-		return nil, nil, errBufferUnderflow
+	model.Classifier, err = deserializer(&features, model.ClassifierData)
+	if err != nil {
+		return nil, err
 	}
+	return model, nil
+}
 
-	deserializer, ok := Deserializers[string(nameData)]
-	if !ok {
-		return nil, nil, fmt.Errorf("unknown classifier type: %s", string(nameData))
+// ReadMetadata decodes the metadata of a model in any
+// format version, without decoding the rest of it.
+// For older models, the metadata only contains what
+// the file recorded.
+func ReadMetadata(d []byte) (*ModelMetadata, error) {
+	model, _, err := readModelSections(d)
+	if err != nil {
+		return nil, err
 	}
+	return model.Metadata, nil
+}
 
-	class, err := deserializer(&features, b.Bytes())
+// MigrateModel rewrites a model of any format version
+// in the latest one.
+// The model is fully decoded first, so that a damaged
+// file is not given a valid checksum.
+func MigrateModel(d []byte) ([]byte, error) {
+	model, sections, err := readModelSections(d)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ReadModel(d); err != nil {
+		return nil, err
+	}
+	return serializeSections(model.Metadata, sections.features, model.Buckets,
+		model.Metadata.Classifier, model.ClassifierData), nil
+}
+
+// modelSections holds the parts of a model which are
+// decoded lazily.
+type modelSections struct {
+	features []byte
+}
+
+// readModelSections splits up a model and decodes its
+// metadata and score buckets.
+func readModelSections(d []byte) (*Model, *modelSections, error) {
+	if len(d) >= len(modelMagic) && string(d[:len(modelMagic)]) == modelMagic {
+		return readVersionedModel(d)
+	}
+	return readLegacyModel(d)
+}
+
+func readVersionedModel(d []byte) (*Model, *modelSections, error) {
+	r := bytes.NewReader(d[len(modelMagic):])
+	var version uint32
+	if err := binary.Read(r, serializeByteOrder, &version); err != nil {
+		return nil, nil, ErrTruncated
+	}
+	if version != ModelFormatVersion {
+		return nil, nil, fmt.Errorf("unsupported model format version: %d", version)
+	}
+
+	var parts [5][]byte
+	for i := range parts {
+		var err error
+		if parts[i], err = readSection(r); err != nil {
+			return nil, nil, err
+		}
+	}
+	var checksum uint32
+	if err := binary.Read(r, serializeByteOrder, &checksum); err != nil {
+		return nil, nil, ErrTruncated
+	}
+	if r.Len() != 0 || crc32.ChecksumIEEE(d[:len(d)-4]) != checksum {
+		return nil, nil, ErrCorrupted
+	}
+
+	var meta ModelMetadata
+	if err := json.Unmarshal(parts[0], &meta); err != nil {
+		return nil, nil, fmt.Errorf("decode metadata: %s", err)
+	}
+	var buckets ScoreBuckets
+	if err := json.Unmarshal(parts[2], &buckets); err != nil {
+		return nil, nil, fmt.Errorf("decode score buckets: %s", err)
+	}
+	meta.Classifier = string(parts[3])
+	meta.Cutoffs = buckets.Cutoffs
+	model := &Model{
+		Version:        int(version),
+		Metadata:       &meta,
+		Buckets:        &buckets,
+		ClassifierData: parts[4],
+	}
+	return model, &modelSections{features: parts[1]}, nil
+}
+
+// readLegacyModel reads a version 0 model.
+// These have no checksum, so damage is only detected
+// if it breaks the structure of the file or the
+// network.
+func readLegacyModel(d []byte) (*Model, *modelSections, error) {
+	r := bytes.NewReader(d)
+	featureData, err := readSection(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	nameData, err := readSection(r)
 	if err != nil {
 		return nil, nil, err
 	}
+	if string(nameData) != "neuralnet" {
+		return nil, nil, fmt.Errorf("unknown classifier type: %s", nameData)
+	}
+
+	buckets := &ScoreBuckets{Cutoffs: append([]int{}, baselineCutoffs...)}
+	model := &Model{
+		Version: 0,
+		Metadata: &ModelMetadata{
+			Cutoffs:    buckets.Cutoffs,
+			Classifier: "weakai",
+		},
+		Buckets:        buckets,
+		ClassifierData: d[len(d)-r.Len():],
+	}
+	return model, &modelSections{features: featureData}, nil
+}
+
+func writeSection(b *bytes.Buffer, data []byte) {
+	binary.Write(b, serializeByteOrder, uint64(len(data)))
+	b.Write(data)
+}
 
-	return class, &features, nil
+// readSection reads a length-prefixed section,
+// returning ErrTruncated if the data ends early.
+func readSection(r *bytes.Reader) ([]byte, error) {
+	var lenField uint64
+	if err := binary.Read(r, serializeByteOrder, &lenField); err != nil {
+		return nil, ErrTruncated
+	}
+	if lenField > uint64(r.Len()) {
+		return nil, ErrTruncated
+	}
+	data := make([]byte, int(lenField))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, ErrTruncated
+	}
+	return data, nil
 }
@@ -0,0 +1,171 @@
+package hnclass
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/weakai/neuralnet"
+)
+
+func TestSerializeRoundTrip(t *testing.T) {
+	stories, net, buckets := serializeTestModel(t)
+	meta := &ModelMetadata{
+		Created:  time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		DataHash: "abc",
+		Config:   json.RawMessage(`{"split":"random"}`),
+		Metrics:  &ModelMetrics{Accuracy: 0.5},
+	}
+	data := Serialize(net, net.featureMap, buckets, meta)
+
+	model, err := ReadModel(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Version != ModelFormatVersion {
+		t.Errorf("expected version %d but got %d", ModelFormatVersion, model.Version)
+	}
+	if !reflect.DeepEqual(model.Buckets, buckets) {
+		t.Errorf("expected buckets %v but got %v", buckets, model.Buckets)
+	}
+	if model.Metadata.DataHash != meta.DataHash || !model.Metadata.Created.Equal(meta.Created) ||
+		string(model.Metadata.Config) != string(meta.Config) ||
+		model.Metadata.Metrics.Accuracy != meta.Metrics.Accuracy {
+		t.Errorf("expected metadata %+v but got %+v", meta, model.Metadata)
+	}
+	if model.Metadata.Classifier != "neuralnet" ||
+		!reflect.DeepEqual(model.Metadata.Cutoffs, buckets.Cutoffs) {
+		t.Errorf("unexpected classifier %s and cutoffs %v", model.Metadata.Classifier,
+			model.Metadata.Cutoffs)
+	}
+	for _, s := range stories {
+		expected := net.ClassifyProba(NewFeatureVector(s, net.featureMap))
+		actual := model.Classifier.ClassifyProba(NewFeatureVector(s, model.Features))
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected probabilities %v but got %v", expected, actual)
+		}
+	}
+}
+
+func TestReadModelTruncated(t *testing.T) {
+	_, net, buckets := serializeTestModel(t)
+	data := Serialize(net, net.featureMap, buckets, &ModelMetadata{})
+	for size := len(modelMagic); size < len(data); size++ {
+		if _, err := ReadModel(data[:size]); err != ErrTruncated {
+			t.Fatalf("size %d: expected ErrTruncated but got %v", size, err)
+		}
+	}
+}
+
+func TestReadModelCorrupted(t *testing.T) {
+	_, net, buckets := serializeTestModel(t)
+	data := Serialize(net, net.featureMap, buckets, &ModelMetadata{})
+
+	// Flipping a byte in the classifier data keeps every
+	// section intact, so only the checksum catches it.
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-10] ^= 1
+	if _, err := ReadModel(corrupted); err != ErrCorrupted {
+		t.Errorf("expected ErrCorrupted but got %v", err)
+	}
+	if _, err := MigrateModel(corrupted); err != ErrCorrupted {
+		t.Errorf("expected ErrCorrupted from MigrateModel but got %v", err)
+	}
+}
+
+func TestMigrateVersion0(t *testing.T) {
+	stories, net, _ := serializeTestModel(t)
+	m := net.featureMap
+	weakaiNet, err := neuralnet.NewNetwork([]neuralnet.LayerPrototype{
+		&neuralnet.DenseParams{
+			Activation:  neuralnet.Sigmoid{},
+			InputCount:  m.VectorSize(),
+			OutputCount: 3,
+		},
+		&neuralnet.DenseParams{
+			Activation:  neuralnet.Sigmoid{},
+			InputCount:  3,
+			OutputCount: len(baselineCutoffs) + 1,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakaiNet.Randomize()
+	networkData, err := weakaiNet.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Version 0 feature maps only had these fields.
+	featureData, _ := json.Marshal(map[string]interface{}{
+		"TitleKeywords":   m.TitleKeywords,
+		"ContentKeywords": m.ContentKeywords,
+		"HostNames":       m.HostNames,
+		"Offset":          -2.5,
+		"Scale":           0.4,
+	})
+	var b bytes.Buffer
+	writeSection(&b, featureData)
+	writeSection(&b, []byte("neuralnet"))
+	b.Write(networkData)
+
+	old, err := ReadModel(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := MigrateModel(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := ReadModel(migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []*Model{old, model} {
+		if x.Metadata.Classifier != "weakai" ||
+			!reflect.DeepEqual(x.Buckets.Cutoffs, baselineCutoffs) {
+			t.Errorf("version %d: unexpected classifier %s and cutoffs %v", x.Version,
+				x.Metadata.Classifier, x.Buckets.Cutoffs)
+		}
+	}
+	if old.Version != 0 || model.Version != ModelFormatVersion {
+		t.Errorf("unexpected versions %d and %d", old.Version, model.Version)
+	}
+	for _, s := range stories {
+		expected := old.Classifier.ClassifyProba(NewFeatureVector(s, old.Features))
+		actual := model.Classifier.ClassifyProba(NewFeatureVector(s, model.Features))
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected probabilities %v but got %v", expected, actual)
+		}
+	}
+}
+
+// serializeTestModel makes a small untrained network
+// along with the stories its feature map came from.
+func serializeTestModel(t *testing.T) ([]*StoryData, *NeuralNet, *ScoreBuckets) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	stories := []*StoryData{
+		{Title: "Show HN: a parser", Content: "parser code", HostName: "a.com", Time: start},
+		{Title: "Ask HN: parsers", Content: "which parser", HostName: "b.com",
+			Time: start.Add(time.Hour)},
+	}
+	c := DefaultConfig()
+	c.Features.ContentUbiquity = 1
+	m, err := NewFeatureMap(stories, &c.Features)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.NeuralNet.HiddenCount = 4
+	c.NeuralNet.StepSize = 0.1
+	buckets := &ScoreBuckets{Cutoffs: []int{2, 10}}
+	net, err := NewNeuralNet(m, buckets, &c.NeuralNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	net.network.randomize(rand.New(rand.NewSource(1337)))
+	return stories, net, buckets
+}
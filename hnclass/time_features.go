@@ -0,0 +1,122 @@
+package hnclass
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	// Embed the time zone database so that a model's
+	// time zone loads on machines without one.
+	_ "time/tzdata"
+)
+
+const (
+	defaultTimeZone = "America/Los_Angeles"
+
+	// timeFeaturesSize is the number of features in the
+	// extra time block: the sine and cosine of the time
+	// of day and of the day of the week, a weekend flag,
+	// a holiday flag and the front page gap.
+	timeFeaturesSize = 7
+
+	// maxFrontPageGap is the gap at which the front page
+	// gap feature saturates.
+	maxFrontPageGap = 24 * time.Hour
+)
+
+// localTime converts a story's time to the time zone
+// of m.
+// Maps without a time zone predate the setting and
+// use the machine's local time, as they always did.
+func (m *FeatureMap) localTime(t time.Time, index *featureIndex) time.Time {
+	if index.location == nil {
+		return t.Local()
+	}
+	return t.In(index.location)
+}
+
+// timeFeatures computes the extra time block for a
+// story, given its local time.
+func timeFeatures(s *StoryData, local time.Time) []float64 {
+	dayFrac := (float64(local.Hour()) + float64(local.Minute())/60) / 24
+	weekFrac := float64(local.Weekday()) / 7
+	weekend := local.Weekday() == time.Saturday || local.Weekday() == time.Sunday
+
+	var gap float64
+	if s.FrontPageGap > 0 {
+		gap = math.Min(1, math.Log1p(s.FrontPageGap.Minutes())/
+			math.Log1p(maxFrontPageGap.Minutes()))
+	}
+
+	return []float64{
+		math.Sin(2 * math.Pi * dayFrac),
+		math.Cos(2 * math.Pi * dayFrac),
+		math.Sin(2 * math.Pi * weekFrac),
+		math.Cos(2 * math.Pi * weekFrac),
+		boolFeature(weekend),
+		boolFeature(isUSHoliday(local)),
+		gap,
+	}
+}
+
+// AddFrontPageGaps sets the FrontPageGap of every story
+// to the time since the most recent history story which
+// reached minScore points, as a rough measure of the
+// competition a story faced.
+// Only history stories posted strictly before a story
+// are considered, so a list of stories may be its own
+// history.
+func AddFrontPageGaps(stories, history []*StoryData, historyScores []int, minScore int) {
+	var frontPage []time.Time
+	for i, s := range history {
+		if historyScores[i] >= minScore {
+			frontPage = append(frontPage, s.Time)
+		}
+	}
+	sort.Slice(frontPage, func(i, j int) bool {
+		return frontPage[i].Before(frontPage[j])
+	})
+
+	for _, s := range stories {
+		s.FrontPageGap = 0
+		idx := sort.Search(len(frontPage), func(i int) bool {
+			return !frontPage[i].Before(s.Time)
+		})
+		if idx > 0 {
+			s.FrontPageGap = s.Time.Sub(frontPage[idx-1])
+		}
+	}
+}
+
+// isUSHoliday reports whether a date is a US federal
+// holiday (ignoring observed dates for weekends).
+func isUSHoliday(t time.Time) bool {
+	month, day := t.Month(), t.Day()
+	weekday := t.Weekday()
+	nth := (day-1)/7 + 1
+	lastOfMonth := day+7 > daysIn(month, t.Year())
+
+	switch {
+	case month == time.January && day == 1,
+		month == time.July && day == 4,
+		month == time.November && day == 11,
+		month == time.December && day == 25:
+		return true
+	case month == time.June && day == 19:
+		// Juneteenth became a federal holiday in 2021.
+		return t.Year() >= 2021
+	case weekday == time.Monday:
+		return (month == time.January && nth == 3) ||
+			(month == time.February && nth == 3) ||
+			(month == time.May && lastOfMonth) ||
+			(month == time.September && nth == 1) ||
+			(month == time.October && nth == 2)
+	case weekday == time.Thursday:
+		return month == time.November && nth == 4
+	}
+	return false
+}
+
+func daysIn(month time.Month, year int) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
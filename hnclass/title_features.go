@@ -0,0 +1,91 @@
+package hnclass
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// titleStructureSize is the number of features in the
+// title structure block.
+const titleStructureSize = 12
+
+const (
+	maxTitleChars = 80
+	maxTitleWords = 15
+)
+
+var (
+	titleYearExpr  = regexp.MustCompile(`\((19|20)\d\d\)\s*$`)
+	titleDigitExpr = regexp.MustCompile(`\d`)
+)
+
+// titleStructureFeatures describes the shape of a
+// title, independently of the words in it.
+// Counts are divided by typical maximums so that every
+// feature is roughly between 0 and 1, like the flags.
+func titleStructureFeatures(title string) []float64 {
+	title = strings.TrimSpace(title)
+	lower := strings.ToLower(title)
+	words := strings.Fields(title)
+
+	prefixFree := title
+	for _, prefix := range []string{"show hn:", "ask hn:", "launch hn:", "tell hn:"} {
+		if strings.HasPrefix(lower, prefix) {
+			prefixFree = title[len(prefix):]
+			break
+		}
+	}
+
+	return []float64{
+		math.Min(1, float64(len([]rune(title)))/maxTitleChars),
+		math.Min(1, float64(len(words))/maxTitleWords),
+		boolFeature(strings.HasPrefix(lower, "show hn:")),
+		boolFeature(strings.HasPrefix(lower, "ask hn:")),
+		boolFeature(strings.HasPrefix(lower, "launch hn:")),
+		boolFeature(strings.Contains(title, "?")),
+		boolFeature(titleDigitExpr.MatchString(titleYearExpr.ReplaceAllString(title, ""))),
+		boolFeature(titleYearExpr.MatchString(title)),
+		boolFeature(strings.Contains(lower, "[pdf]")),
+		boolFeature(strings.Contains(lower, "[video]")),
+		allCapsRatio(words),
+		boolFeature(strings.Contains(prefixFree, ":")),
+	}
+}
+
+// allCapsRatio returns the fraction of words with at
+// least two letters in which every letter is upper
+// case, ignoring the HN in prefixes like "Show HN".
+func allCapsRatio(words []string) float64 {
+	var caps, total int
+	for _, word := range words {
+		var letters, upper int
+		for _, r := range word {
+			if unicode.IsLetter(r) {
+				letters++
+				if unicode.IsUpper(r) {
+					upper++
+				}
+			}
+		}
+		if letters < 2 || strings.HasPrefix(word, "HN") {
+			continue
+		}
+		total++
+		if upper == letters {
+			caps++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(caps) / float64(total)
+}
+
+func boolFeature(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
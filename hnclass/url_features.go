@@ -0,0 +1,144 @@
+package hnclass
+
+import (
+	"math"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// urlFlagsSize is the number of features in the fixed
+// URL block: the path depth followed by one flag per
+// known platform.
+const urlFlagsSize = 5
+
+const (
+	maxPathDepth         = 5
+	defaultPathUbiquity  = 2
+	defaultURLUbiquity   = 1
+	maxExtensionLength   = 5
+	minPathTokenLength   = 2
+	githubRepoPathLength = 2
+)
+
+// urlParts holds the pieces of a story URL which are
+// used as features.
+type urlParts struct {
+	Domain     string
+	TLD        string
+	Extension  string
+	PathTokens []string
+	Flags      []float64
+}
+
+func parseURLParts(rawURL string) *urlParts {
+	res := &urlParts{Flags: make([]float64, urlFlagsSize)}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return res
+	}
+
+	host := strings.ToLower(u.Hostname())
+	res.Domain = host
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		res.Domain = domain
+	}
+	res.TLD, _ = publicsuffix.PublicSuffix(host)
+
+	var segments []string
+	for _, s := range strings.Split(u.Path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	if ext := strings.ToLower(path.Ext(u.Path)); len(ext) > 1 && len(ext) <= maxExtensionLength {
+		res.Extension = ext
+	}
+
+	seen := map[string]bool{}
+	for _, token := range strings.FieldsFunc(strings.ToLower(u.Path), isPathSeparator) {
+		if len(token) >= minPathTokenLength && !seen[token] {
+			seen[token] = true
+			res.PathTokens = append(res.PathTokens, token)
+		}
+	}
+
+	res.Flags[0] = math.Min(1, float64(len(segments))/maxPathDepth)
+	res.Flags[1] = boolFeature(res.Domain == "github.com" &&
+		len(segments) >= githubRepoPathLength)
+	res.Flags[2] = boolFeature(res.Domain == "arxiv.org")
+	res.Flags[3] = boolFeature(res.Domain == "youtube.com" || res.Domain == "youtu.be")
+	res.Flags[4] = boolFeature(res.Domain == "twitter.com" || res.Domain == "x.com")
+
+	return res
+}
+
+func isPathSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// urlVocabulary accumulates the URL parts seen in a
+// list of stories.
+type urlVocabulary struct {
+	domains    map[string]int
+	tlds       map[string]int
+	extensions map[string]int
+	pathTokens map[string]int
+}
+
+func newURLVocabulary() *urlVocabulary {
+	return &urlVocabulary{
+		domains:    map[string]int{},
+		tlds:       map[string]int{},
+		extensions: map[string]int{},
+		pathTokens: map[string]int{},
+	}
+}
+
+func (u *urlVocabulary) add(rawURL string) {
+	parts := parseURLParts(rawURL)
+	if parts.Domain != "" {
+		u.domains[parts.Domain]++
+	}
+	if parts.TLD != "" {
+		u.tlds[parts.TLD]++
+	}
+	if parts.Extension != "" {
+		u.extensions[parts.Extension]++
+	}
+	for _, token := range parts.PathTokens {
+		u.pathTokens[token]++
+	}
+}
+
+// urlFeatures computes the URL blocks of a feature
+// vector, starting at the given index.
+func (m *FeatureMap) urlFeatures(startIdx int, rawURL string, index *featureIndex) []FeatureValue {
+	parts := parseURLParts(rawURL)
+
+	var res []FeatureValue
+	oneHot := func(values []string, blockIndex map[string]int, size int) {
+		var block []FeatureValue
+		for _, v := range values {
+			if i, ok := blockIndex[v]; ok {
+				block = append(block, FeatureValue{startIdx + i, 1})
+			}
+		}
+		res = append(res, sortedFeatures(block)...)
+		startIdx += size
+	}
+	oneHot([]string{parts.Domain}, index.domain, len(m.Domains))
+	oneHot(parts.PathTokens, index.pathToken, len(m.PathTokens))
+	oneHot([]string{parts.Extension}, index.extension, len(m.Extensions))
+	oneHot([]string{parts.TLD}, index.tld, len(m.TLDs))
+
+	for i, x := range parts.Flags {
+		if x != 0 {
+			res = append(res, FeatureValue{startIdx + i, x})
+		}
+	}
+	return res
+}
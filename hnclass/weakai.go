@@ -0,0 +1,80 @@
+package hnclass
+
+import (
+	"math"
+	"sync"
+
+	"github.com/unixpickle/weakai/neuralnet"
+)
+
+// weakaiMaxOutput keeps the sigmoid outputs of a
+// WeakaiNet away from 0 and 1, where their logits are
+// infinite.
+const weakaiMaxOutput = 1 - 1e-12
+
+// A WeakaiNet is a network trained by older versions of
+// this package, which used weakai: one sigmoid hidden
+// layer and a sigmoid output per class, trained to
+// minimize the squared error.
+// Such networks can classify stories, but they cannot
+// be trained any further.
+type WeakaiNet struct {
+	featureMap *FeatureMap
+
+	// lock guards the network's input and output
+	// buffers, which are shared by every call.
+	lock    sync.Mutex
+	network *neuralnet.Network
+}
+
+func DeserializeWeakaiNet(m *FeatureMap, d []byte) (*WeakaiNet, error) {
+	net, err := neuralnet.DeserializeNetwork(d)
+	if err != nil {
+		return nil, err
+	}
+	return &WeakaiNet{featureMap: m, network: net}, nil
+}
+
+func (w *WeakaiNet) Serialize() []byte {
+	data, err := w.network.Serialize()
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func (w *WeakaiNet) SerializerType() string {
+	return "weakai"
+}
+
+func (w *WeakaiNet) Classify(vec FeatureVector) int {
+	return argmax(w.ClassifyProba(vec))
+}
+
+// ClassifyProba applies a softmax to the logits of the
+// network's sigmoid outputs.
+// The outputs were not trained as probabilities, but
+// this keeps their order, so the most likely class is
+// the one the network was always taken to predict.
+func (w *WeakaiNet) ClassifyProba(vec FeatureVector) []float64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	inputVec := w.network.Input()
+	for i := range inputVec {
+		inputVec[i] = 0
+	}
+	for _, v := range vec {
+		inputVec[v.Index] = v.Value
+	}
+	w.network.PropagateForward()
+
+	output := w.network.Output()
+	res := make([]float64, len(output))
+	for i, o := range output {
+		o = math.Min(math.Max(o, 1-weakaiMaxOutput), weakaiMaxOutput)
+		res[i] = math.Log(o / (1 - o))
+	}
+	applyActivation(softmaxActivation, res)
+	return res
+}
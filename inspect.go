@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/unixpickle/hn-ranker/hnclass"
+)
+
+const (
+	DefaultInspectSamples = 10
+	DefaultInspectTop     = 10
+)
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "inspect",
+		Summary: "Describe the features, structure and metadata of a model.",
+		Args:    "<model>",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Setup: func(flags *flag.FlagSet) func(args []string) error {
+			format := addFormatFlag(flags)
+			samples := flags.Int("samples", DefaultInspectSamples,
+				"number of vocabulary entries to show per block")
+			top := flags.Int("top", DefaultInspectTop,
+				"number of top weighted keywords and hosts to show per bucket")
+			return func(args []string) error {
+				if err := checkFormat(*format); err != nil {
+					return err
+				}
+				if *samples < 0 || *top < 0 {
+					return errors.New("sample and top counts must not be negative")
+				}
+				report, err := InspectModel(args[0], *samples, *top)
+				if err != nil {
+					return err
+				}
+				if *format == JSONFormat {
+					return writeJSON(report)
+				}
+				report.print()
+				return nil
+			}
+		},
+	})
+}
+
+// A modelReport is the output of the inspect command.
+type modelReport struct {
+	File          string                 `json:"file"`
+	FormatVersion int                    `json:"format_version"`
+	Classifier    string                 `json:"classifier"`
+	Metadata      *hnclass.ModelMetadata `json:"metadata"`
+	Buckets       []string               `json:"buckets"`
+
+	VectorSize int           `json:"vector_size"`
+	Blocks     []blockReport `json:"blocks"`
+
+	// Layers and Params are only known for neural
+	// networks.
+	Layers []hnclass.LayerInfo `json:"layers,omitempty"`
+	Params int                 `json:"params,omitempty"`
+
+	// TopWeights is only set for linear classifiers.
+	TopWeights []bucketWeights `json:"top_weights,omitempty"`
+}
+
+type blockReport struct {
+	Name    string   `json:"name"`
+	Size    int      `json:"size"`
+	Samples []string `json:"samples,omitempty"`
+}
+
+type bucketWeights struct {
+	Bucket string        `json:"bucket"`
+	Blocks []topFeatures `json:"blocks"`
+}
+
+type topFeatures struct {
+	Block    string            `json:"block"`
+	Positive []weightedFeature `json:"positive"`
+	Negative []weightedFeature `json:"negative"`
+}
+
+type weightedFeature struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight"`
+}
+
+// InspectModel reads a model and describes it.
+// Up to samples entries of each vocabulary are listed,
+// along with up to top keywords and hosts per bucket
+// for linear classifiers.
+func InspectModel(path string, samples, top int) (*modelReport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	model, err := hnclass.ReadModel(data)
+	if err != nil {
+		return nil, err
+	}
+
+	features := model.Features
+	report := &modelReport{
+		File:          path,
+		FormatVersion: model.Version,
+		Classifier:    model.Metadata.Classifier,
+		Metadata:      model.Metadata,
+		Buckets:       model.Buckets.Labels(),
+		VectorSize:    features.VectorSize(),
+	}
+	for _, block := range features.FeatureBlocks() {
+		if block.Size == 0 {
+			continue
+		}
+		report.Blocks = append(report.Blocks, blockReport{
+			Name:    block.Name,
+			Size:    block.Size,
+			Samples: vocabSamples(features, block, samples),
+		})
+	}
+
+	if net, ok := model.Classifier.(*hnclass.NeuralNet); ok {
+		report.Layers = net.Layers()
+		for _, layer := range report.Layers {
+			report.Params += layer.Params
+		}
+	}
+	if linear, ok := model.Classifier.(hnclass.LinearClassifier); ok {
+		if weights := linear.ClassWeights(); weights != nil {
+			report.TopWeights = topWeights(features, model.Buckets, weights, top)
+		}
+	}
+	return report, nil
+}
+
+// vocabSamples lists some of the names in a block,
+// preferring the most common keywords when the
+// document frequencies are known.
+func vocabSamples(m *hnclass.FeatureMap, block hnclass.FeatureBlock, count int) []string {
+	var docFreqs []int
+	hashed := m.HashBuckets > 0
+	switch block.Name {
+	case hnclass.ContentBlock:
+		docFreqs = m.ContentDocFreqs
+	case hnclass.TitleBlock:
+		docFreqs = m.TitleDocFreqs
+	case hnclass.HostBlock:
+	case hnclass.DomainBlock, hnclass.PathBlock, hnclass.ExtensionBlock, hnclass.TLDBlock:
+		hashed = false
+	default:
+		return nil
+	}
+	if hashed {
+		return nil
+	}
+	if len(docFreqs) != block.Size {
+		docFreqs = nil
+	}
+
+	indices := make([]int, block.Size)
+	for i := range indices {
+		indices[i] = i
+	}
+	if docFreqs != nil {
+		sort.SliceStable(indices, func(i, j int) bool {
+			return docFreqs[indices[i]] > docFreqs[indices[j]]
+		})
+	}
+	if len(indices) > count {
+		indices = indices[:count]
+	}
+	var res []string
+	for _, i := range indices {
+		_, name := m.FeatureName(block.Start + i)
+		res = append(res, name)
+	}
+	return res
+}
+
+// topWeights finds the keywords and hosts with the
+// largest and smallest weights for each bucket.
+// Since only the differences between the classes
+// matter to a softmax, each weight is taken relative
+// to the mean weight of its feature over all classes.
+func topWeights(m *hnclass.FeatureMap, buckets *hnclass.ScoreBuckets, weights [][]float64,
+	count int) []bucketWeights {
+	means := make([]float64, len(weights[0]))
+	for _, row := range weights {
+		for i, w := range row {
+			means[i] += w / float64(len(weights))
+		}
+	}
+
+	var res []bucketWeights
+	for class, row := range weights {
+		bucket := bucketWeights{Bucket: buckets.Label(class)}
+		for _, block := range m.FeatureBlocks() {
+			switch block.Name {
+			case hnclass.ContentBlock, hnclass.TitleBlock, hnclass.HostBlock:
+			default:
+				continue
+			}
+			if block.Size == 0 {
+				continue
+			}
+			var features []weightedFeature
+			for i := block.Start; i < block.Start+block.Size; i++ {
+				_, name := m.FeatureName(i)
+				features = append(features, weightedFeature{
+					Name:   name,
+					Weight: row[i] - means[i],
+				})
+			}
+			sort.SliceStable(features, func(i, j int) bool {
+				return features[i].Weight > features[j].Weight
+			})
+			top := topFeatures{Block: block.Name}
+			for i := 0; i < count && i < len(features) && features[i].Weight > 0; i++ {
+				top.Positive = append(top.Positive, features[i])
+			}
+			for i := len(features) - 1; i >= 0 && len(top.Negative) < count &&
+				features[i].Weight < 0; i-- {
+				top.Negative = append(top.Negative, features[i])
+			}
+			bucket.Blocks = append(bucket.Blocks, top)
+		}
+		res = append(res, bucket)
+	}
+	return res
+}
+
+func (r *modelReport) print() {
+	meta := r.Metadata
+	fmt.Println("Model:", r.File)
+	fmt.Println("Format version:", r.FormatVersion)
+	fmt.Println("Classifier:", r.Classifier)
+	if meta.Created.IsZero() {
+		fmt.Println("Created: unknown")
+	} else {
+		fmt.Println("Created:", meta.Created.Local().Format("2006-01-02 15:04:05 MST"))
+	}
+	if meta.DataHash != "" {
+		fmt.Println("Training data:", meta.DataHash)
+	}
+	if m := meta.Metrics; m != nil {
+		fmt.Printf("Cross validation: accuracy %0.4f, macro-F1 %0.4f, log loss %0.4f "+
+			"(%d stories)\n", m.Accuracy, m.MacroF1, m.LogLoss, m.Count)
+	}
+	fmt.Printf("Buckets: %s (cutoffs %v)\n", strings.Join(r.Buckets, " "), meta.Cutoffs)
+
+	fmt.Printf("\nFeature blocks (%d features):\n", r.VectorSize)
+	for _, block := range r.Blocks {
+		fmt.Printf("  %-18s %7d", block.Name, block.Size)
+		if len(block.Samples) > 0 {
+			fmt.Printf("  %s", strings.Join(block.Samples, ", "))
+		}
+		fmt.Println()
+	}
+
+	if len(r.Layers) > 0 {
+		fmt.Printf("\nLayers (%d parameters):\n", r.Params)
+		for _, layer := range r.Layers {
+			fmt.Printf("  %d -> %d %s (%d parameters)\n", layer.Inputs, layer.Outputs,
+				layer.Activation, layer.Params)
+		}
+	}
+
+	for _, bucket := range r.TopWeights {
+		fmt.Printf("\nTop weights for bucket %s (relative to the mean over buckets):\n",
+			bucket.Bucket)
+		for _, block := range bucket.Blocks {
+			fmt.Printf("  %s +: %s\n", block.Block, formatWeights(block.Positive))
+			fmt.Printf("  %s -: %s\n", block.Block, formatWeights(block.Negative))
+		}
+	}
+
+	if len(meta.Config) > 0 {
+		var config interface{}
+		if err := json.Unmarshal(meta.Config, &config); err == nil {
+			data, _ := json.MarshalIndent(config, "", "  ")
+			fmt.Printf("\nConfig: %s\n", data)
+		}
+	}
+}
+
+func formatWeights(features []weightedFeature) string {
+	if len(features) == 0 {
+		return "(none)"
+	}
+	parts := make([]string, len(features))
+	for i, f := range features {
+		parts[i] = fmt.Sprintf("%s (%+0.3f)", f.Name, f.Weight)
+	}
+	return strings.Join(parts, ", ")
+}
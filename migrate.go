@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+
+	"github.com/unixpickle/hn-ranker/hnclass"
+)
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "migrate",
+		Summary: "Rewrite a model saved by an older version in the current format.",
+		Args:    "<model-in> <model-out>",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Setup: func(flags *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				return MigrateModel(args[0], args[1])
+			}
+		},
+	})
+}
+
+// MigrateModel reads a model in an older format version
+// and saves it in the current one.
+// Older formats lack most of the metadata, so it is
+// left empty.
+// Their weakai networks are kept as they are, and still
+// compute the hour and weekday features in the local
+// time zone.
+func MigrateModel(inFile, outFile string) error {
+	data, err := ioutil.ReadFile(inFile)
+	if err != nil {
+		return err
+	}
+	metadata, err := hnclass.ReadMetadata(data)
+	if err != nil {
+		return err
+	}
+	migrated, err := hnclass.MigrateModel(data)
+	if err != nil {
+		return err
+	}
+	log.Printf("Migrated %s classifier with cutoffs %v to format version %d",
+		metadata.Classifier, metadata.Cutoffs, hnclass.ModelFormatVersion)
+	return ioutil.WriteFile(outFile, migrated, 0755)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -10,10 +11,30 @@ import (
 	"time"
 )
 
-const minPostAge = time.Hour * 24 * 3
+const DefaultMinPostAge = time.Hour * 24 * 3
 
-func SaveStories(output string) error {
-	storyChan, errs := FetchStoryItems(time.Now().Add(-minPostAge))
+func init() {
+	RegisterCommand(&Command{
+		Name:    "stories",
+		Summary: "Fetch story metadata until Ctrl+C is pressed.",
+		Args:    "<output.json>",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Setup: func(flags *flag.FlagSet) func(args []string) error {
+			minAge := flags.Duration("min-age", DefaultMinPostAge,
+				"only fetch stories at least this old, so their scores have settled")
+			return func(args []string) error {
+				return SaveStories(args[0], *minAge)
+			}
+		},
+	})
+}
+
+// SaveStories fetches stories which are at least
+// minAge old, newest first, and saves them to a JSON
+// file once the user presses Ctrl+C.
+func SaveStories(output string, minAge time.Duration) error {
+	storyChan, errs := FetchStoryItems(time.Now().Add(-minAge))
 
 	var stories []*StoryItem
 
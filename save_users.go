@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// userSaveInterval is the number of users fetched
+// between saves of the output file.
+const userSaveInterval = 100
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "users",
+		Summary: "Fetch the profile of every story's submitter.",
+		Args:    "<list.json> <users.json>",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Setup: func(flags *flag.FlagSet) func(args []string) error {
+			workers := flags.Int("workers", SimultaneousReqCount, "number of simultaneous requests")
+			return func(args []string) error {
+				if *workers < 1 {
+					return errors.New("there must be at least one worker")
+				}
+				return SaveUsers(args[0], args[1], *workers)
+			}
+		},
+	})
+}
+
+// SaveUsers fetches the submitter of every story in a
+// list and saves them to a JSON file mapping user IDs
+// to UserItems, or to null for users which no longer
+// exist.
+// Users already present in the output file are not
+// fetched again, so an interrupted run can resume.
+// Progress is saved every so often and when the user
+// presses Ctrl+C.
+func SaveUsers(listFile, output string, workers int) error {
+	stories, err := readStoryList(listFile)
+	if err != nil {
+		return err
+	}
+
+	users, err := readUserCache(output)
+	if err != nil {
+		return err
+	}
+
+	terminateChan := make(chan struct{})
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		<-c
+		signal.Stop(c)
+		fmt.Println("\nCaught interrupt. Ctrl+C again to terminate.")
+		close(terminateChan)
+	}()
+
+	userChan := make(chan string)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var fetched int
+	var saveErr error
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range userChan {
+				user, err := FetchUser(id)
+				if err != nil {
+					log.Printf("Error fetching user %s: %s", id, err.Error())
+					continue
+				}
+				lock.Lock()
+				users[id] = user
+				if user == nil {
+					log.Printf("User %s does not exist (%d users)", id, len(users))
+				} else {
+					log.Printf("Gotten user %s (%d users)", id, len(users))
+				}
+				fetched++
+				if fetched%userSaveInterval == 0 && saveErr == nil {
+					saveErr = writeUserCache(output, users)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	queued := map[string]bool{}
+QueueLoop:
+	for _, story := range stories {
+		if _, ok := users[story.By]; story.By == "" || queued[story.By] || ok {
+			continue
+		}
+		queued[story.By] = true
+		select {
+		case userChan <- story.By:
+		case <-terminateChan:
+			break QueueLoop
+		}
+	}
+	close(userChan)
+
+	wg.Wait()
+
+	if saveErr != nil {
+		return saveErr
+	}
+	return writeUserCache(output, users)
+}
+
+func writeUserCache(path string, users map[string]*UserItem) error {
+	data, err := json.Marshal(users)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0755)
+}
+
+// readUserCache reads a file written by SaveUsers.
+// A missing file is treated as an empty cache.
+func readUserCache(path string) (map[string]*UserItem, error) {
+	users := map[string]*UserItem{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return users, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
@@ -3,17 +3,46 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"flag"
 	"io/ioutil"
 	"log"
 	"strconv"
 )
 
-func ScoresAbove(listFile string, threshold string) error {
-	thresholdNum, err := strconv.Atoi(threshold)
-	if err != nil {
-		return errors.New("invalid threshold: " + threshold)
-	}
+func init() {
+	RegisterCommand(&Command{
+		Name:    "scoresabove",
+		Summary: "Count the stories with a score above a threshold.",
+		Args:    "<list.json> [score]",
+		MinArgs: 1,
+		MaxArgs: 2,
+		Setup: func(flags *flag.FlagSet) func(args []string) error {
+			threshold := flags.Int("threshold", -1, "the score to count stories above "+
+				"(may also be given as the second argument)")
+			format := addFormatFlag(flags)
+			return func(args []string) error {
+				if len(args) == 2 {
+					if *threshold >= 0 {
+						return newUsageError("the threshold was given twice")
+					}
+					num, err := strconv.Atoi(args[1])
+					if err != nil {
+						return errors.New("invalid threshold: " + args[1])
+					}
+					*threshold = num
+				} else if *threshold < 0 {
+					return newUsageError("missing threshold")
+				}
+				if err := checkFormat(*format); err != nil {
+					return err
+				}
+				return ScoresAbove(args[0], *threshold, *format)
+			}
+		},
+	})
+}
 
+func ScoresAbove(listFile string, threshold int, format string) error {
 	data, err := ioutil.ReadFile(listFile)
 	if err != nil {
 		return err
@@ -26,12 +55,19 @@ func ScoresAbove(listFile string, threshold string) error {
 
 	var count, total int
 	for _, s := range list {
-		if s.Score > thresholdNum {
+		if s.Score > threshold {
 			count++
 		}
 		total++
 	}
 
+	if format == JSONFormat {
+		return writeJSON(map[string]int{
+			"threshold": threshold,
+			"matched":   count,
+			"total":     total,
+		})
+	}
 	log.Printf("Matched %d/%d (%0.2f%%)", count, total, 100*float64(count)/float64(total))
 	return nil
 }
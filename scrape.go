@@ -3,6 +3,8 @@ package main
 import (
 	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"flag"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -29,14 +31,37 @@ const (
 
 var scrapeClient http.Client
 
-func Scrape(inputFile, outputDir string) error {
+func init() {
+	RegisterCommand(&Command{
+		Name:    "scrape",
+		Summary: "Download the linked article of every story.",
+		Args:    "<list.json> <output-dir>",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Setup: func(flags *flag.FlagSet) func(args []string) error {
+			workers := flags.Int("workers", SimultaneousReqCount, "number of simultaneous requests")
+			timeout := flags.Duration("timeout", RequestTimeout, "timeout for each request")
+			return func(args []string) error {
+				if *workers < 1 {
+					return errors.New("there must be at least one worker")
+				}
+				return Scrape(args[0], args[1], *workers, *timeout)
+			}
+		},
+	})
+}
+
+// Scrape fetches the article for every story in a list
+// and saves its text to outputDir.
+// Stories which were already fetched are skipped.
+func Scrape(inputFile, outputDir string, workers int, timeout time.Duration) error {
 	cookies, _ := cookiejar.New(nil)
 	scrapeClient = http.Client{
 		Jar: cookies,
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		},
-		Timeout: RequestTimeout,
+		Timeout: timeout,
 	}
 
 	var list []*StoryItem
@@ -54,7 +79,7 @@ func Scrape(inputFile, outputDir string) error {
 
 	postChan := make(chan *StoryItem)
 	var wg sync.WaitGroup
-	for i := 0; i < SimultaneousReqCount; i++ {
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
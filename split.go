@@ -0,0 +1,262 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/unixpickle/hn-ranker/hnclass"
+)
+
+// These are the ways of choosing which stories are
+// held out for cross validation.
+const (
+	// RandomSplit holds out a random sample of stories.
+	RandomSplit = "random"
+
+	// StratifiedSplit holds out a random sample of the
+	// stories in each score bucket, so that both sets
+	// have the same mix of buckets.
+	StratifiedSplit = "stratified"
+
+	// ChronologicalSplit holds out the newest stories,
+	// optionally dropping the stories posted within a
+	// gap before them so that training stories never
+	// overlap with the held out ones in time.
+	ChronologicalSplit = "chronological"
+)
+
+// These environment variables override the split
+// settings in a config file.
+const (
+	SplitEnvVar     = "HN_SPLIT"
+	SplitSeedEnvVar = "HN_SPLIT_SEED"
+	SplitGapEnvVar  = "HN_SPLIT_GAP"
+)
+
+// A splitConfig describes how to divide stories
+// into training and cross validation sets.
+type splitConfig struct {
+	method string
+	gen    *rand.Rand
+	gap    time.Duration
+
+	// config is used to group stories by bucket
+	// for stratified splits.
+	config *Config
+}
+
+func getSplitConfig(c *Config) (*splitConfig, error) {
+	gap, err := c.splitGap()
+	if err != nil {
+		return nil, err
+	}
+	return &splitConfig{
+		method: c.Split,
+		gen:    rand.New(rand.NewSource(c.SplitSeed)),
+		gap:    gap,
+		config: c,
+	}, nil
+}
+
+// splitStories reorders stories and their scores so
+// that the first crossCount of them are for cross
+// validation and the rest are for training.
+// Stories which fall into no set are dropped.
+func splitStories(stories []*hnclass.StoryData, scores []int,
+	c *Config) (resStories []*hnclass.StoryData, resScores []int, crossCount int,
+	err error) {
+	config, err := getSplitConfig(c)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	crossFrac := c.CrossFrac
+
+	var order []int
+	switch config.method {
+	case RandomSplit:
+		order = config.gen.Perm(len(stories))
+		crossCount = int(crossFrac * float64(len(stories)))
+	case StratifiedSplit:
+		order, crossCount, err = stratifiedOrder(scores, c, config.gen)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	case ChronologicalSplit:
+		order, crossCount = chronologicalOrder(stories, crossFrac, config.gap)
+	}
+
+	for _, idx := range order {
+		resStories = append(resStories, stories[idx])
+		resScores = append(resScores, scores[idx])
+	}
+	return
+}
+
+// splitValidation reorders the training stories, which
+// follow the first crossCount stories, so that the
+// first validationCount of them can be held out from
+// training in the same way as cross validation stories.
+// The split gap is not applied, since the validation
+// stories only decide when to stop training.
+func splitValidation(stories []*hnclass.StoryData, scores []int, crossCount int,
+	c *Config) (resStories []*hnclass.StoryData, resScores []int, validationCount int,
+	err error) {
+	inner := *c
+	inner.CrossFrac = c.ValidationFrac
+	inner.SplitGap = ""
+	training, trainingScores, validationCount, err := splitStories(stories[crossCount:],
+		scores[crossCount:], &inner)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	resStories = append(append(resStories, stories[:crossCount]...), training...)
+	resScores = append(append(resScores, scores[:crossCount]...), trainingScores...)
+	return
+}
+
+// makeFolds assigns every story to one of k folds
+// for k-fold cross validation.
+// Random and stratified folds are shuffled, while
+// chronological folds are contiguous ranges of time.
+func (s *splitConfig) makeFolds(stories []*hnclass.StoryData, scores []int,
+	k int) ([]int, error) {
+	folds := make([]int, len(stories))
+	switch s.method {
+	case RandomSplit:
+		for i, idx := range s.gen.Perm(len(stories)) {
+			folds[idx] = i % k
+		}
+	case StratifiedSplit:
+		buckets, err := makeScoreBuckets(scores, s.config)
+		if err != nil {
+			return nil, err
+		}
+		groups := make([][]int, buckets.ClassCount())
+		for i, score := range scores {
+			class := buckets.Class(score)
+			groups[class] = append(groups[class], i)
+		}
+		var offset int
+		for _, group := range groups {
+			for i, j := range s.gen.Perm(len(group)) {
+				folds[group[j]] = (offset + i) % k
+			}
+			offset += len(group)
+		}
+	case ChronologicalSplit:
+		order, _ := chronologicalOrder(stories, 0, 0)
+		for i, idx := range order {
+			folds[idx] = i * k / len(order)
+		}
+	}
+	return folds, nil
+}
+
+// foldStories makes a copy of the stories and scores
+// with the given fold first, for cross validation,
+// followed by the stories for training.
+// Training stories posted within the gap of the
+// fold's time range are dropped.
+// The stories are copied so that each fold can
+// modify its own.
+func (s *splitConfig) foldStories(stories []*hnclass.StoryData, scores, folds []int,
+	fold int) (resStories []*hnclass.StoryData, resScores []int, crossCount int) {
+	var minTime, maxTime time.Time
+	var training []int
+	for i, story := range stories {
+		if folds[i] != fold {
+			training = append(training, i)
+			continue
+		}
+		resStories = append(resStories, copyStory(story))
+		resScores = append(resScores, scores[i])
+		if crossCount == 0 || story.Time.Before(minTime) {
+			minTime = story.Time
+		}
+		if crossCount == 0 || story.Time.After(maxTime) {
+			maxTime = story.Time
+		}
+		crossCount++
+	}
+
+	minTime, maxTime = minTime.Add(-s.gap), maxTime.Add(s.gap)
+	for _, i := range training {
+		t := stories[i].Time
+		if s.gap > 0 && !t.Before(minTime) && !t.After(maxTime) {
+			continue
+		}
+		resStories = append(resStories, copyStory(stories[i]))
+		resScores = append(resScores, scores[i])
+	}
+	return
+}
+
+func copyStory(s *hnclass.StoryData) *hnclass.StoryData {
+	res := *s
+	if s.Author != nil {
+		author := *s.Author
+		res.Author = &author
+	}
+	return &res
+}
+
+// stratifiedOrder shuffles the stories of each score
+// bucket and takes the same fraction of every bucket
+// for cross validation.
+// The buckets here only group the stories; the ones
+// used for training are computed later from the
+// training scores alone.
+func stratifiedOrder(scores []int, c *Config, gen *rand.Rand) ([]int, int, error) {
+	buckets, err := makeScoreBuckets(scores, c)
+	if err != nil {
+		return nil, 0, err
+	}
+	groups := make([][]int, buckets.ClassCount())
+	for i, score := range scores {
+		class := buckets.Class(score)
+		groups[class] = append(groups[class], i)
+	}
+
+	var cross, training []int
+	for _, group := range groups {
+		gen.Shuffle(len(group), func(i, j int) {
+			group[i], group[j] = group[j], group[i]
+		})
+		count := int(c.CrossFrac*float64(len(group)) + 0.5)
+		cross = append(cross, group[:count]...)
+		training = append(training, group[count:]...)
+	}
+	return append(cross, training...), len(cross), nil
+}
+
+// chronologicalOrder puts the newest stories first
+// and leaves out training stories which were posted
+// less than gap before the oldest held out story.
+func chronologicalOrder(stories []*hnclass.StoryData, crossFrac float64,
+	gap time.Duration) ([]int, int) {
+	order := make([]int, len(stories))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return stories[order[i]].Time.After(stories[order[j]].Time)
+	})
+	crossCount := int(crossFrac * float64(len(stories)))
+	if crossCount == 0 || gap == 0 {
+		return order, crossCount
+	}
+
+	cutoff := stories[order[crossCount-1]].Time.Add(-gap)
+	res := append([]int{}, order[:crossCount]...)
+	for _, idx := range order[crossCount:] {
+		if stories[idx].Time.Before(cutoff) {
+			res = append(res, idx)
+		}
+	}
+	if dropped := len(order) - len(res); dropped > 0 {
+		log.Printf("Dropped %d stories within %s of the cross validation set", dropped, gap)
+	}
+	return res, crossCount
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/unixpickle/hn-ranker/hnclass"
+)
+
+func TestStratifiedFolds(t *testing.T) {
+	stories, scores := splitTestStories(200)
+	c := DefaultConfig()
+	c.Split = StratifiedSplit
+	c.ScoreBuckets = "2,10,50"
+	split, err := getSplitConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const k = 5
+	folds, err := split.makeFolds(stories, scores, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buckets, _ := hnclass.ParseScoreBuckets(c.ScoreBuckets, nil)
+	classCounts := make([]int, buckets.ClassCount())
+	foldCounts := make([][]int, k)
+	for i := range foldCounts {
+		foldCounts[i] = make([]int, buckets.ClassCount())
+	}
+	for i, score := range scores {
+		class := buckets.Class(score)
+		classCounts[class]++
+		foldCounts[folds[i]][class]++
+	}
+	for fold, counts := range foldCounts {
+		for class, count := range counts {
+			if count != classCounts[class]/k && count != (classCounts[class]+k-1)/k {
+				t.Errorf("fold %d has %d of the %d stories in class %d", fold, count,
+					classCounts[class], class)
+			}
+		}
+	}
+}
+
+func TestChronologicalFoldGap(t *testing.T) {
+	stories, scores := splitTestStories(100)
+	c := DefaultConfig()
+	c.SplitGap = "5h"
+	split, err := getSplitConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const k = 4
+	folds, err := split.makeFolds(stories, scores, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fold := 0; fold < k; fold++ {
+		foldData, _, crossCount := split.foldStories(stories, scores, folds, fold)
+		minTime, maxTime := foldData[0].Time, foldData[0].Time
+		for _, s := range foldData[:crossCount] {
+			if s.Time.Before(minTime) {
+				minTime = s.Time
+			}
+			if s.Time.After(maxTime) {
+				maxTime = s.Time
+			}
+		}
+		if span := maxTime.Sub(minTime); span != time.Duration(crossCount-1)*time.Hour {
+			t.Errorf("fold %d: %d stories span %s", fold, crossCount, span)
+		}
+		for _, s := range foldData[crossCount:] {
+			if s.Time.After(minTime.Add(-split.gap)) && s.Time.Before(maxTime.Add(split.gap)) {
+				t.Errorf("fold %d: training story at %s is within %s of %s-%s", fold, s.Time,
+					split.gap, minTime, maxTime)
+			}
+		}
+		expectedTraining := len(stories) - crossCount - 2*5
+		if fold == 0 || fold == k-1 {
+			expectedTraining += 5
+		}
+		if actual := len(foldData) - crossCount; actual != expectedTraining {
+			t.Errorf("fold %d: expected %d training stories but got %d", fold,
+				expectedTraining, actual)
+		}
+	}
+}
+
+func TestFoldsNonEmpty(t *testing.T) {
+	stories, scores := splitTestStories(7)
+	for _, method := range []string{RandomSplit, StratifiedSplit, ChronologicalSplit} {
+		c := DefaultConfig()
+		c.Split = method
+		c.ScoreBuckets = "2,10,50"
+		split, err := getSplitConfig(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k := 2; k <= len(stories); k++ {
+			folds, err := split.makeFolds(stories, scores, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sizes := make([]int, k)
+			for _, fold := range folds {
+				sizes[fold]++
+			}
+			for fold, size := range sizes {
+				if size == 0 {
+					t.Errorf("%s: fold %d of %d is empty", method, fold, k)
+				}
+			}
+		}
+	}
+}
+
+func TestSplitValidation(t *testing.T) {
+	stories, scores := splitTestStories(100)
+	c := DefaultConfig()
+	c.CrossFrac = 0.3
+	c.ValidationFrac = 0.2
+	c.SplitGap = "3h"
+	stories, scores, crossCount, err := splitStories(stories, scores, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resStories, resScores, validationCount, err := splitValidation(stories, scores,
+		crossCount, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resStories) != len(stories) || len(resScores) != len(scores) {
+		t.Fatalf("expected %d stories but got %d", len(stories), len(resStories))
+	}
+	if expected := int(0.2 * float64(len(stories)-crossCount)); validationCount != expected {
+		t.Errorf("expected %d validation stories but got %d", expected, validationCount)
+	}
+	for i, s := range resStories[:crossCount] {
+		if s != stories[i] {
+			t.Fatalf("cross validation story %d was moved", i)
+		}
+	}
+
+	// Validation stories are the newest training ones,
+	// and all of them are older than the cross
+	// validation stories.
+	validation := resStories[crossCount : crossCount+validationCount]
+	for _, s := range validation {
+		if !s.Time.Before(resStories[crossCount-1].Time) {
+			t.Errorf("validation story at %s overlaps cross validation", s.Time)
+		}
+		for _, other := range resStories[crossCount+validationCount:] {
+			if !other.Time.Before(s.Time) {
+				t.Fatalf("training story at %s is newer than validation story at %s",
+					other.Time, s.Time)
+			}
+		}
+	}
+}
+
+// splitTestStories makes stories posted an hour apart,
+// whose scores are mostly low, as they are on HN.
+func splitTestStories(count int) ([]*hnclass.StoryData, []int) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	stories := make([]*hnclass.StoryData, count)
+	scores := make([]int, count)
+	for i := range stories {
+		stories[i] = &hnclass.StoryData{Time: start.Add(time.Duration(i) * time.Hour)}
+		scores[i] = []int{1, 1, 1, 3, 1, 12, 1, 4, 80, 1, 2}[i%11]
+	}
+	return stories, scores
+}
@@ -1,77 +1,296 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/url"
-	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/unixpickle/hn-ranker/hnclass"
 )
 
-var OutputScoreCutoffs = []int{2, 5, 10, 50}
-
 const (
-	DefaultCrossFrac = 0.3
+	DefaultCrossFrac      = 0.3
+	DefaultValidationFrac = 0.1
+	DefaultScoreBuckets   = "2,5,10,50"
+)
 
+// These environment variables override the settings
+// in a config file, as listed in envSettings.
+const (
 	ClassifierNameEnvVar = "HN_CLASSIFIER"
 	CrossFracEnvVar      = "HN_CROSS_VALIDATION_FRAC"
+	ScoreBucketsEnvVar   = "HN_SCORE_BUCKETS"
+	UsersFileEnvVar      = "HN_USERS_FILE"
+	FrontPageScoreEnvVar = "HN_FRONT_PAGE_SCORE"
+
+	FeatureSelectionEnvVar = "HN_FEATURE_SELECTION"
+	ContentTopKEnvVar      = "HN_CONTENT_TOP_K"
+	TitleTopKEnvVar        = "HN_TITLE_TOP_K"
+	HostTopKEnvVar         = "HN_HOST_TOP_K"
 )
 
-func Train(storyListFile, postDump, classifierOut string) error {
-	crossFrac := DefaultCrossFrac
-	if cfVar := os.Getenv(CrossFracEnvVar); cfVar != "" {
-		var err error
-		crossFrac, err = strconv.ParseFloat(cfVar, 64)
+func init() {
+	RegisterCommand(&Command{
+		Name:    "train",
+		Summary: "Train a classifier and save it to a file.",
+		Args:    "<list.json> <post-dir> <classifier-out>",
+		MinArgs: 3,
+		MaxArgs: 3,
+		Setup:   setupTrain,
+	})
+}
+
+func setupTrain(flags *flag.FlagSet) func(args []string) error {
+	configFlags := addConfigFlags(flags)
+	unattended := flags.Bool("unattended", false,
+		"train until the stopping criteria are met, without waiting for Ctrl+C")
+	metricsFile := flags.String("metrics-file", "",
+		"write cross validation metrics to this JSON file")
+	return func(args []string) error {
+		config, err := configFlags.load()
 		if err != nil {
-			return fmt.Errorf("invalid %s environment variable", CrossFracEnvVar)
+			return err
 		}
+		return Train(args[0], args[1], args[2], config, *unattended, *metricsFile)
 	}
+}
 
-	log.Println("Parsing story list...")
-	stories, err := readStoryList(storyListFile)
+// Train trains a classifier and saves it to outFile.
+// If unattended is true, training stops on its own
+// instead of waiting for Ctrl+C.
+// If metricsFile is not empty, the cross validation
+// metrics are saved to it.
+func Train(storyList, postDir, outFile string, config *Config, unattended bool,
+	metricsFile string) error {
+	storyData, scores, err := readTrainingData(storyList, postDir, config)
 	if err != nil {
 		return err
 	}
+	dataHash := hashTrainingData(storyData, scores)
 
-	log.Println("Reading story data...")
-	storyData, scores := loadStoryData(stories, postDump)
+	log.Println("Splitting stories...")
+	storyData, scores, crossCount, err := splitStories(storyData, scores, config)
+	if err != nil {
+		return err
+	}
+	log.Printf("Split: %d training, %d cross validation", len(storyData)-crossCount,
+		crossCount)
+
+	model, err := trainModel(storyData, scores, crossCount, config, !unattended)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Saving classifier...")
+	configData, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	metadata := &hnclass.ModelMetadata{
+		Created:  time.Now().UTC(),
+		DataHash: dataHash,
+		Config:   configData,
+	}
+	if m := model.evaluate(); m.Total > 0 {
+		log.Printf("Cross validation: %s", m)
+		metadata.Metrics = m.Summary()
+	}
+	data := hnclass.Serialize(model.classifier, model.features, model.buckets, metadata)
+	if err := ioutil.WriteFile(outFile, data, 0755); err != nil {
+		return err
+	}
+
+	if metricsFile != "" {
+		if metadata.Metrics == nil {
+			return errors.New("metrics need a cross validation set")
+		}
+		data, err := json.Marshal(metadata.Metrics)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(metricsFile, data, 0755)
+	}
+	return nil
+}
+
+// hashTrainingData computes a hash which identifies
+// the stories and scores a model is trained on.
+func hashTrainingData(storyData []*hnclass.StoryData, scores []int) string {
+	h := sha256.New()
+	for i, s := range storyData {
+		var author string
+		if s.Author != nil {
+			author = s.Author.Name
+		}
+		fields := []string{s.Title, s.URL, s.Content, author,
+			strconv.FormatInt(s.Time.Unix(), 10), strconv.Itoa(scores[i])}
+		for _, field := range fields {
+			binary.Write(h, binary.LittleEndian, uint64(len(field)))
+			io.WriteString(h, field)
+		}
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// A trainedModel is the result of trainModel.
+type trainedModel struct {
+	classifier hnclass.TrainableClassifier
+	features   *hnclass.FeatureMap
+	buckets    *hnclass.ScoreBuckets
+	crossData  *hnclass.TrainingData
+}
+
+// evaluate computes the metrics for the cross
+// validation set.
+func (t *trainedModel) evaluate() *hnclass.Metrics {
+	return hnclass.Evaluate(t.classifier, t.crossData, t.buckets.ClassCount())
+}
+
+// trainModel builds the features and trains a new
+// classifier, using the stories after crossCount for
+// training and the rest for cross validation.
+// Some of the training stories are held out with
+// splitValidation to decide when to stop, so the cross
+// validation stories play no part in training.
+// The stories may be modified, since things like
+// author history depend on which stories are used
+// for training.
+// If interactive is false, the classifier must stop
+// training on its own.
+func trainModel(storyData []*hnclass.StoryData, scores []int, crossCount int,
+	config *Config, interactive bool) (*trainedModel, error) {
+	storyData, scores, validationCount, err := splitValidation(storyData, scores, crossCount,
+		config)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Holding out %d training stories for validation", validationCount)
+
+	if config.Features.AuthorProfileCounts {
+		log.Println("Warning: author karma and submission counts include the points of " +
+			"the stories being scored, so validation metrics will be optimistic.")
+	}
+	if hasAuthors(storyData) {
+		log.Println("Computing author history...")
+		hnclass.AddAuthorHistory(storyData, storyData[crossCount:], scores[crossCount:])
+	}
+
+	// Unlike author history, the gaps use every story,
+	// since the scores of earlier stories are known by
+	// the time a new story is ranked.
+	if config.FrontPageScore > 0 {
+		log.Println("Computing front page gaps...")
+		hnclass.AddFrontPageGaps(storyData, storyData, scores, config.FrontPageScore)
+	}
+
+	log.Println("Computing score buckets...")
+	buckets, err := makeScoreBuckets(scores[crossCount:], config)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Score buckets: %s", strings.Join(buckets.Labels(), " "))
+	classes := makeClasses(scores, buckets)
 
 	log.Println("Creating feature map...")
-	features := hnclass.NewFeatureMap(storyData)
-	log.Printf("Feature counts: %d content, %d title, %d hostname",
-		len(features.ContentKeywords), len(features.TitleKeywords), len(features.HostNames))
+	features, err := hnclass.NewFeatureMap(storyData[crossCount:], &config.Features)
+	if err != nil {
+		return nil, err
+	}
+	if config.FeatureSelection != "" {
+		log.Println("Selecting features...")
+		err = features.SelectFeatures(storyData[crossCount:], classes[crossCount:],
+			buckets.ClassCount(), config.FeatureSelection, config.selectionLimits())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if features.HashBuckets > 0 {
+		log.Printf("Hashing features into %d buckets per block", features.HashBuckets)
+	} else {
+		log.Printf("Feature counts: %d content, %d title, %d hostname",
+			len(features.ContentKeywords), len(features.TitleKeywords), len(features.HostNames))
+	}
 
 	log.Println("Initializing classifier...")
-	classifier, err := makeClassifier(features, len(OutputScoreCutoffs)+1)
+	classifier, err := makeClassifier(features, buckets, config)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	log.Println("Making feature/class vectors...")
-	vecs := makeFeatureVectors(storyData, features)
-	classes := makeClasses(scores)
+	vecs := hnclass.NewFeatureVectors(storyData, features)
 
 	log.Println("Training...")
-	crossCount := int(crossFrac * float64(len(vecs)))
+	trainStart := crossCount + validationCount
 	trainingData := &hnclass.TrainingData{
-		Vectors: vecs[crossCount:],
-		Classes: classes[crossCount:],
+		Vectors: vecs[trainStart:],
+		Classes: classes[trainStart:],
+	}
+	validationData := &hnclass.TrainingData{
+		Vectors: vecs[crossCount:trainStart],
+		Classes: classes[crossCount:trainStart],
 	}
 	crossData := &hnclass.TrainingData{
 		Vectors: vecs[:crossCount],
 		Classes: classes[:crossCount],
 	}
-	classifier.Train(trainingData, crossData)
+	if interactive {
+		classifier.Train(trainingData, validationData)
+	} else if err := classifier.TrainUnattended(trainingData, validationData); err != nil {
+		return nil, err
+	}
 
-	log.Println("Saving classifier...")
-	data := hnclass.Serialize(classifier, features)
-	return ioutil.WriteFile(classifierOut, data, 0755)
+	return &trainedModel{
+		classifier: classifier,
+		features:   features,
+		buckets:    buckets,
+		crossData:  crossData,
+	}, nil
+}
+
+// readTrainingData reads the stories which have been
+// scraped, along with their scores.
+func readTrainingData(storyListFile, postDump string,
+	config *Config) ([]*hnclass.StoryData, []int, error) {
+	log.Println("Parsing story list...")
+	stories, err := readStoryList(storyListFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var users map[string]*UserItem
+	if config.UsersFile != "" {
+		log.Println("Reading user list...")
+		users, err = readUserCache(config.UsersFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	log.Println("Reading story data...")
+	storyData, scores := loadStoryData(stories, postDump, users)
+	return storyData, scores, nil
+}
+
+func hasAuthors(storyData []*hnclass.StoryData) bool {
+	for _, s := range storyData {
+		if s.Author != nil {
+			return true
+		}
+	}
+	return false
 }
 
 func readStoryList(listPath string) ([]*StoryItem, error) {
@@ -88,8 +307,8 @@ func readStoryList(listPath string) ([]*StoryItem, error) {
 	return stories, nil
 }
 
-func loadStoryData(stories []*StoryItem, postDump string) (data []*hnclass.StoryData,
-	scores []int) {
+func loadStoryData(stories []*StoryItem, postDump string,
+	users map[string]*UserItem) (data []*hnclass.StoryData, scores []int) {
 	for _, story := range stories {
 		fileName := strconv.FormatInt(story.ID, 10) + ".txt"
 		postFile := filepath.Join(postDump, fileName)
@@ -106,50 +325,45 @@ func loadStoryData(stories []*StoryItem, postDump string) (data []*hnclass.Story
 		storyData := &hnclass.StoryData{
 			Title:    story.Title,
 			Content:  string(contents),
+			URL:      story.URL,
 			HostName: hostString,
 			Time:     time.Unix(story.Time, 0),
 		}
+		if user := users[story.By]; user != nil {
+			storyData.Author = &hnclass.AuthorData{
+				Name:        user.ID,
+				Karma:       user.Karma,
+				Created:     time.Unix(user.Created, 0),
+				Submissions: len(user.Submitted),
+			}
+		}
 		data = append(data, storyData)
 		scores = append(scores, story.Score)
 	}
 	return
 }
 
-func makeClassifier(features *hnclass.FeatureMap,
-	classCount int) (hnclass.TrainableClassifier, error) {
-	classifierName := os.Getenv(ClassifierNameEnvVar)
-	if classifierName == "" {
-		return nil, fmt.Errorf("missing %s environment variable", ClassifierNameEnvVar)
-	}
-	maker, ok := hnclass.ClassifierMakers[classifierName]
-	if !ok {
-		return nil, fmt.Errorf("invalid classifier name: %s", classifierName)
-	}
-	classifier, err := maker(features, classCount)
+func makeScoreBuckets(trainingScores []int, config *Config) (*hnclass.ScoreBuckets, error) {
+	buckets, err := hnclass.ParseScoreBuckets(config.ScoreBuckets, trainingScores)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid score_buckets: %s", err)
 	}
-	return classifier, nil
+	return buckets, nil
 }
 
-func makeFeatureVectors(data []*hnclass.StoryData, m *hnclass.FeatureMap) []hnclass.FeatureVector {
-	res := make([]hnclass.FeatureVector, len(data))
-	for i, s := range data {
-		res[i] = hnclass.NewFeatureVector(s, m)
+func makeClassifier(features *hnclass.FeatureMap, buckets *hnclass.ScoreBuckets,
+	config *Config) (hnclass.TrainableClassifier, error) {
+	maker, ok := hnclass.ClassifierMakers[config.Classifier]
+	if !ok {
+		return nil, fmt.Errorf("invalid classifier name: %s", config.Classifier)
 	}
-	return res
+	return maker(features, buckets, &config.Config)
 }
 
-func makeClasses(scores []int) []int {
+func makeClasses(scores []int, buckets *hnclass.ScoreBuckets) []int {
 	classes := make([]int, len(scores))
 	for i, score := range scores {
-		var class int
-		for _, c := range OutputScoreCutoffs {
-			if score >= c {
-				class++
-			}
-		}
-		classes[i] = class
+		classes[i] = buckets.Class(score)
 	}
 	return classes
 }
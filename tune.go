@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/unixpickle/hn-ranker/hnclass"
+)
+
+// These are the supported search methods.
+const (
+	GridSearch    = "grid"
+	RandomSearch  = "random"
+	HalvingSearch = "halving"
+)
+
+const (
+	maxEpochsSetting     = "neuralnet.max_epochs"
+	patienceSetting      = "neuralnet.patience"
+	checkpointDirSetting = "neuralnet.checkpoint_dir"
+)
+
+const (
+	defaultTrialCount     = 10
+	defaultHalvingFactor  = 3
+	defaultHalvingEpochs  = 1
+	tuneResultsFileName   = "results.jsonl"
+	tuneLeaderboardName   = "leaderboard.tsv"
+	tuneBestModelFileName = "best-model"
+)
+
+// A searchSpace describes a hyperparameter search.
+// Params maps settings (such as neuralnet.step_size
+// or features.title_ubiquity) to the values to try,
+// while Base holds settings which are the same for
+// every trial.
+// Settings may also be named by their environment
+// variables, such as NEURALNET_STEP_SIZE.
+type searchSpace struct {
+	Method string                 `json:"method"`
+	Trials int                    `json:"trials"`
+	Seed   int64                  `json:"seed"`
+	Base   map[string]string      `json:"base"`
+	Params map[string]*paramRange `json:"params"`
+
+	// MinEpochs, MaxEpochs and Factor configure
+	// successive halving: every round trains the
+	// remaining trials for Factor times as many
+	// epochs as the last, keeping the best 1/Factor
+	// of them.
+	MinEpochs int `json:"min_epochs"`
+	MaxEpochs int `json:"max_epochs"`
+	Factor    int `json:"factor"`
+}
+
+// A paramRange is either a list of values or, for
+// random search, a numeric range.
+// In JSON, a list is written as an array and a range
+// as an object like {"min": 1e-4, "max": 1e-1,
+// "log": true}.
+type paramRange struct {
+	Values []string
+
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	Log bool    `json:"log"`
+	Int bool    `json:"int"`
+}
+
+func (p *paramRange) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		type rangeObj paramRange
+		return json.Unmarshal(data, (*rangeObj)(p))
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for _, r := range raw {
+		var str string
+		if err := json.Unmarshal(r, &str); err != nil {
+			str = string(bytes.TrimSpace(r))
+		}
+		p.Values = append(p.Values, str)
+	}
+	return nil
+}
+
+// sample picks a random value from the range.
+func (p *paramRange) sample(gen *rand.Rand) string {
+	if p.Values != nil {
+		return p.Values[gen.Intn(len(p.Values))]
+	}
+	var x float64
+	if p.Log {
+		x = math.Exp(math.Log(p.Min) + gen.Float64()*(math.Log(p.Max)-math.Log(p.Min)))
+	} else {
+		x = p.Min + gen.Float64()*(p.Max-p.Min)
+	}
+	if p.Int {
+		return strconv.Itoa(int(math.Round(x)))
+	}
+	return strconv.FormatFloat(x, 'g', 4, 64)
+}
+
+func readSearchSpace(path string) (*searchSpace, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var res searchSpace
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	if res.Method == "" {
+		res.Method = GridSearch
+	}
+	if res.Trials == 0 {
+		res.Trials = defaultTrialCount
+	}
+	if res.Factor == 0 {
+		res.Factor = defaultHalvingFactor
+	}
+	if res.MinEpochs == 0 {
+		res.MinEpochs = defaultHalvingEpochs
+	}
+
+	base := map[string]string{}
+	for name, value := range res.Base {
+		base[settingName(name)] = value
+	}
+	params := map[string]*paramRange{}
+	for name, p := range res.Params {
+		params[settingName(name)] = p
+	}
+	res.Base, res.Params = base, params
+
+	return &res, res.validate()
+}
+
+// settingName returns the setting for a name which
+// may be an environment variable.
+func settingName(name string) string {
+	if key, ok := envSetting(name); ok {
+		return key
+	}
+	return name
+}
+
+func (s *searchSpace) validate() error {
+	switch s.Method {
+	case GridSearch, RandomSearch, HalvingSearch:
+	default:
+		return errors.New("unknown search method: " + s.Method)
+	}
+	if len(s.Params) == 0 {
+		return errors.New("no params to search")
+	}
+	for name, p := range s.Params {
+		if p.Values == nil {
+			if s.Method == GridSearch {
+				return fmt.Errorf("param %s: grid search needs a list of values", name)
+			}
+			if p.Max < p.Min || (p.Log && p.Min <= 0) {
+				return fmt.Errorf("param %s: invalid range", name)
+			}
+		} else if len(p.Values) == 0 {
+			return fmt.Errorf("param %s: no values", name)
+		}
+	}
+	if s.Method == HalvingSearch {
+		if s.MaxEpochs < s.MinEpochs || s.Factor < 2 {
+			return errors.New("halving search needs max_epochs >= min_epochs and factor >= 2")
+		}
+		if _, ok := s.Params[maxEpochsSetting]; ok {
+			return fmt.Errorf("halving search sets %s itself", maxEpochsSetting)
+		}
+	}
+	return nil
+}
+
+// checkStopping makes sure that every trial will
+// stop training on its own, given the config which
+// the trials start from.
+func (s *searchSpace) checkStopping(config *Config) error {
+	if s.Method == HalvingSearch {
+		return nil
+	}
+	for _, key := range []string{maxEpochsSetting, patienceSetting} {
+		if _, ok := s.Params[key]; ok {
+			return nil
+		}
+	}
+	if config.NeuralNet.MaxEpochs == 0 && config.NeuralNet.Patience == 0 {
+		return fmt.Errorf("trials need %s or %s to stop on their own", maxEpochsSetting,
+			patienceSetting)
+	}
+	return nil
+}
+
+// configs lists the parameter settings to try.
+// For random and halving search, this depends only
+// on the seed, so a resumed search tries the same
+// settings.
+func (s *searchSpace) configs() []map[string]string {
+	var names []string
+	for name := range s.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if s.Method == GridSearch {
+		res := []map[string]string{{}}
+		for _, name := range names {
+			var next []map[string]string
+			for _, config := range res {
+				for _, value := range s.Params[name].Values {
+					c := map[string]string{name: value}
+					for k, v := range config {
+						c[k] = v
+					}
+					next = append(next, c)
+				}
+			}
+			res = next
+		}
+		return res
+	}
+
+	gen := rand.New(rand.NewSource(s.Seed))
+	res := make([]map[string]string, s.Trials)
+	for i := range res {
+		res[i] = map[string]string{}
+		for _, name := range names {
+			res[i][name] = s.Params[name].sample(gen)
+		}
+	}
+	return res
+}
+
+// A tuneTrial is one training run in a search.
+type tuneTrial struct {
+	Name   string            `json:"name"`
+	Params map[string]string `json:"params"`
+	Epochs int               `json:"epochs,omitempty"`
+
+	// BaseConfig is a hash of the config which the
+	// params were applied to; see configHash.
+	BaseConfig string `json:"base_config"`
+
+	Metrics *hnclass.ModelMetrics `json:"metrics"`
+}
+
+// key identifies the settings of a trial, so that
+// finished trials can be skipped when resuming.
+func (t *tuneTrial) key() string {
+	return formatParams(t.Params) + " epochs=" + strconv.Itoa(t.Epochs) +
+		" base=" + t.BaseConfig
+}
+
+// configHash hashes the config which every trial
+// starts from, combining the config file, the -set
+// flags and the base settings of the search space.
+// Finished trials are only reused when it matches.
+func configHash(c *Config) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:8]), nil
+}
+
+// A tuner runs trials and records their results.
+type tuner struct {
+	space       *searchSpace
+	configArgs  []string
+	configHash  string
+	storyList   string
+	postDir     string
+	outputDir   string
+	parallelism int
+
+	lock     sync.Mutex
+	finished map[string]*tuneTrial
+	cancel   chan struct{}
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:    "tune",
+		Summary: "Search for hyperparameters, training a model for every trial.",
+		Args:    "<space.json> <list.json> <post-dir> <output-dir>",
+		MinArgs: 4,
+		MaxArgs: 4,
+		Setup: func(flags *flag.FlagSet) func(args []string) error {
+			configFlags := addConfigFlags(flags)
+			parallel := flags.Int("parallel", 1, "number of trials to run at once")
+			return func(args []string) error {
+				return Tune(args[0], args[1], args[2], args[3], configFlags, *parallel)
+			}
+		},
+	})
+}
+
+// Tune runs a hyperparameter search, training a model
+// for every trial with `hn-ranker train`.
+// The config flags are passed along to every trial.
+func Tune(spaceFile, storyList, postDir, outputDir string, configFlags *configFlags,
+	parallel int) error {
+	if parallel < 1 {
+		return errors.New("parallelism must be at least 1")
+	}
+
+	space, err := readSearchSpace(spaceFile)
+	if err != nil {
+		return fmt.Errorf("invalid search space: %s", err)
+	}
+	for name, value := range space.Base {
+		configFlags.sets = append(configFlags.sets, name+"="+value)
+	}
+	baseConfig, err := configFlags.merge()
+	if err != nil {
+		return err
+	}
+	if err := space.checkStopping(baseConfig); err != nil {
+		return err
+	}
+	_, sampled := space.Params[checkpointDirSetting]
+	if baseConfig.NeuralNet.CheckpointDir != "" || sampled {
+		// Parallel trials would overwrite each other's checkpoints.
+		return errors.New(checkpointDirSetting + " cannot be used with tune")
+	}
+
+	hash, err := configHash(baseConfig)
+	if err != nil {
+		return err
+	}
+
+	t := &tuner{
+		space:       space,
+		configArgs:  configFlags.args(),
+		configHash:  hash,
+		storyList:   storyList,
+		postDir:     postDir,
+		outputDir:   outputDir,
+		parallelism: parallel,
+		cancel:      make(chan struct{}),
+	}
+	if err := os.MkdirAll(filepath.Join(t.outputDir, "trials"), 0755); err != nil {
+		return err
+	}
+	if err := t.loadResults(); err != nil {
+		return err
+	}
+
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		<-c
+		signal.Stop(c)
+		log.Println("Caught interrupt; finished trials are saved and will be skipped on resume.")
+		close(t.cancel)
+	}()
+
+	configs := space.configs()
+	var final []*tuneTrial
+	if space.Method == HalvingSearch {
+		final = t.runHalving(configs)
+	} else {
+		final = t.runAll(configs, trialNames(len(configs)), 0)
+	}
+
+	if err := t.writeLeaderboard(); err != nil {
+		return err
+	}
+	if t.cancelled() {
+		return errors.New("search interrupted")
+	}
+	return t.saveBest(final)
+}
+
+// runHalving runs successive halving, returning the
+// trials from the last round.
+func (t *tuner) runHalving(configs []map[string]string) []*tuneTrial {
+	names := trialNames(len(configs))
+	epochs := t.space.MinEpochs
+	for round := 1; ; round++ {
+		log.Printf("Halving round %d: %d trials with %d epochs", round, len(configs), epochs)
+		roundNames := make([]string, len(names))
+		for i, name := range names {
+			roundNames[i] = fmt.Sprintf("%s-e%d", name, epochs)
+		}
+		trials := t.runAll(configs, roundNames, epochs)
+		if t.cancelled() || len(trials) <= 1 || epochs*t.space.Factor > t.space.MaxEpochs {
+			return trials
+		}
+		sortTrials(trials)
+		keep := len(trials) / t.space.Factor
+		if keep < 1 {
+			keep = 1
+		}
+		configs, names = nil, nil
+		for _, trial := range trials[:keep] {
+			configs = append(configs, trial.Params)
+			names = append(names, strings.TrimSuffix(trial.Name, fmt.Sprintf("-e%d", epochs)))
+		}
+		epochs *= t.space.Factor
+	}
+}
+
+func trialNames(count int) []string {
+	res := make([]string, count)
+	for i := range res {
+		res[i] = fmt.Sprintf("trial-%04d", i)
+	}
+	return res
+}
+
+// runAll runs a trial for every config, skipping the
+// ones which already finished.
+// It returns the trials which succeeded.
+func (t *tuner) runAll(configs []map[string]string, names []string,
+	epochs int) []*tuneTrial {
+	trials := make([]*tuneTrial, len(configs))
+	sem := make(chan struct{}, t.parallelism)
+	var wg sync.WaitGroup
+	for i, config := range configs {
+		trial := &tuneTrial{
+			Name:       names[i],
+			Params:     config,
+			Epochs:     epochs,
+			BaseConfig: t.configHash,
+		}
+		if done := t.finishedTrial(trial.key()); done != nil {
+			trials[i] = done
+			continue
+		}
+		wg.Add(1)
+		go func(i int, trial *tuneTrial) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if t.cancelled() {
+				return
+			}
+			if err := t.runTrial(trial); err != nil {
+				log.Printf("Trial %s failed: %s", trial.Name, err)
+				return
+			}
+			log.Printf("Trial %s: log loss %0.4f, accuracy %0.4f", trial.Name,
+				trial.Metrics.LogLoss, trial.Metrics.Accuracy)
+			trials[i] = trial
+		}(i, trial)
+	}
+	wg.Wait()
+
+	var res []*tuneTrial
+	for _, trial := range trials {
+		if trial != nil {
+			res = append(res, trial)
+		}
+	}
+	return res
+}
+
+// runTrial trains a model in a subprocess and saves
+// the result.
+func (t *tuner) runTrial(trial *tuneTrial) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	base := filepath.Join(t.outputDir, "trials", trial.Name)
+	logFile, err := os.Create(base + ".log")
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	args := append([]string{"train", "-unattended", "-metrics-file", base + ".metrics.json"},
+		t.configArgs...)
+	for name, value := range trial.Params {
+		args = append(args, "-set", name+"="+value)
+	}
+	if trial.Epochs > 0 {
+		args = append(args, "-set", maxEpochsSetting+"="+strconv.Itoa(trial.Epochs))
+	}
+	args = append(args, t.storyList, t.postDir, base+".model")
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s (see %s.log)", err, base)
+	}
+	data, err := ioutil.ReadFile(base + ".metrics.json")
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &trial.Metrics); err != nil {
+		return err
+	}
+	return t.recordResult(trial)
+}
+
+func (t *tuner) cancelled() bool {
+	select {
+	case <-t.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *tuner) finishedTrial(key string) *tuneTrial {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.finished[key]
+}
+
+func (t *tuner) recordResult(trial *tuneTrial) error {
+	data, err := json.Marshal(trial)
+	if err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	path := filepath.Join(t.outputDir, tuneResultsFileName)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0755)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	t.finished[trial.key()] = trial
+	return nil
+}
+
+// loadResults reads the trials finished by earlier
+// runs of the same search.
+func (t *tuner) loadResults() error {
+	t.finished = map[string]*tuneTrial{}
+	f, err := os.Open(filepath.Join(t.outputDir, tuneResultsFileName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var stale int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var trial tuneTrial
+		if err := json.Unmarshal(scanner.Bytes(), &trial); err != nil {
+			// A line may be cut off if the search was killed
+			// while writing it.
+			continue
+		}
+		if trial.BaseConfig != t.configHash {
+			stale++
+			continue
+		}
+		t.finished[trial.key()] = &trial
+	}
+	if stale > 0 {
+		log.Printf("Ignoring %d finished trials which used a different config", stale)
+	}
+	if len(t.finished) > 0 {
+		log.Printf("Resuming search with %d finished trials", len(t.finished))
+	}
+	return scanner.Err()
+}
+
+// writeLeaderboard writes every finished trial to a
+// TSV file, best first, and prints the top of it.
+func (t *tuner) writeLeaderboard() error {
+	t.lock.Lock()
+	var trials []*tuneTrial
+	for _, trial := range t.finished {
+		trials = append(trials, trial)
+	}
+	t.lock.Unlock()
+	sortTrials(trials)
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "rank\tname\tepochs\tlog_loss\taccuracy\tmacro_f1\tparams")
+	for i, trial := range trials {
+		fmt.Fprintf(&buf, "%d\t%s\t%d\t%0.4f\t%0.4f\t%0.4f\t%s\n", i+1, trial.Name,
+			trial.Epochs, trial.Metrics.LogLoss, trial.Metrics.Accuracy,
+			trial.Metrics.MacroF1, formatParams(trial.Params))
+		if i < 10 {
+			fmt.Printf("%d. %s: log loss %0.4f, accuracy %0.4f, macro-F1 %0.4f (%s)\n", i+1,
+				trial.Name, trial.Metrics.LogLoss, trial.Metrics.Accuracy,
+				trial.Metrics.MacroF1, formatParams(trial.Params))
+		}
+	}
+	return ioutil.WriteFile(filepath.Join(t.outputDir, tuneLeaderboardName), buf.Bytes(), 0755)
+}
+
+// saveBest copies the model of the best trial to the
+// output directory.
+func (t *tuner) saveBest(trials []*tuneTrial) error {
+	if len(trials) == 0 {
+		return errors.New("no trials succeeded")
+	}
+	sortTrials(trials)
+	best := trials[0]
+	data, err := ioutil.ReadFile(filepath.Join(t.outputDir, "trials", best.Name+".model"))
+	if err != nil {
+		return err
+	}
+	log.Printf("Best trial: %s (%s)", best.Name, formatParams(best.Params))
+	return ioutil.WriteFile(filepath.Join(t.outputDir, tuneBestModelFileName), data, 0755)
+}
+
+// sortTrials sorts trials by cross validation log
+// loss, best first.
+func sortTrials(trials []*tuneTrial) {
+	sort.SliceStable(trials, func(i, j int) bool {
+		return trials[i].Metrics.LogLoss < trials[j].Metrics.LogLoss
+	})
+}
+
+func formatParams(params map[string]string) string {
+	var parts []string
+	for name, value := range params {
+		parts = append(parts, name+"="+value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}